@@ -20,6 +20,7 @@ import (
 var (
 	dryRun    = flag.Bool("n", false, "dry-run mode, don't tag things")
 	overwrite = flag.Bool("o", false, "overwrite existing tags")
+	sidecar   = flag.Bool("sidecar", false, "write tags to XMP sidecars rather than to the images themselves")
 	outDir    = flag.String("out", "", "Location of output directory for thumbnails and cache")
 )
 
@@ -91,16 +92,14 @@ func main() {
 				klog.Errorf("err: %v", err)
 			}
 
-			o := e.ExtractMetadata(i.InPath)
 			klog.Infof("adding tags to %s: %v", i.InPath, tags)
 			if len(tags) > 5 {
 				tags = tags[0:5]
 			}
-			o[0].SetStrings("Keywords", tags)
 			if !*dryRun {
-				e.WriteMetadata(o)
-				if o[0].Err != nil {
-					klog.Errorf("Failed to write metadata for %s: %v", i.InPath, o[0].Err)
+				err := livstid.UpdateKeywords(e, i.InPath, *sidecar, func([]string) []string { return tags })
+				if err != nil {
+					klog.Errorf("Failed to write metadata for %s: %v", i.InPath, err)
 				}
 			}
 		}
@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,55 +27,91 @@ import (
 )
 
 var (
-	outFlag    = flag.String("out", "", "Location of output directory")
-	titleFlag  = flag.String("title", "livstid 📸", "Title of photo collection")
-	descFlag   = flag.String("description", "(insert description here)", "description of photo collection")
-	listenFlag = flag.Bool("listen", false, "serve content via HTTP (read-only)")
-	manageFlag = flag.Bool("manage", false, "serve content via HTTP (writes)")
-	addrFlag   = flag.String("addr", "localhost:12800", "host:port to bind to in listen mode")
-	watchFlag  = flag.Bool("watch", false, "watch for changes to inDir and rebuild")
-	rcloneFlag = flag.String("rclone", "", "rclone target to sync directory contents to")
+	outFlag     = flag.String("out", "", "Location of output directory")
+	titleFlag   = flag.String("title", "livstid 📸", "Title of photo collection")
+	descFlag    = flag.String("description", "(insert description here)", "description of photo collection")
+	listenFlag  = flag.Bool("listen", false, "serve content via HTTP (read-only)")
+	manageFlag  = flag.Bool("manage", false, "serve content via HTTP (writes)")
+	addrFlag    = flag.String("addr", "localhost:12800", "host:port to bind to in listen mode")
+	watchFlag   = flag.Bool("watch", false, "watch for changes to inDir and rebuild")
+	rcloneFlag  = flag.String("rclone", "", "rclone target to sync directory contents to")
+	cacheFlag   = flag.String("cache-dir", "", "Location of metadata cache (defaults to a directory per output directory within the user cache directory)")
+	workersFlag = flag.Int("workers", 0, "number of concurrent thumbnail workers (defaults to GOMAXPROCS)")
+	videoFlag   = flag.Bool("transcode-video", false, "publish videos as web-friendly MP4 renditions (requires ffmpeg)")
+	gazFlag     = flag.String("gazetteer", "", "path to a GeoNames cities file (e.g. cities1000.txt) for offline reverse geocoding")
+	rebuildFlag = flag.Bool("rebuild-cache", false, "ignore the metadata cache and re-read all image metadata")
+	gpsFlag     = flag.String("gps-policy", "keep", "how to publish photo locations: keep, round, or strip")
+	configFlag  = flag.String("config", "", "path to a YAML config file (explicitly set flags take precedence)")
+	eventFlag   = flag.Duration("event-gap", 0, "split albums into events wherever photos are further apart in time than this (e.g. 8h)")
+	tzFlag      = flag.String("display-timezone", "", "IANA time zone to show capture times in, such as Europe/Amsterdam (defaults to the zone each photo was taken in)")
+	sortFlag    = flag.String("index-sort", "path", "order of albums in the index: path or date")
+	dedupeFlag  = flag.String("dedupe", "exact", "how to detect duplicate photos: exact, perceptual (also resized copies and re-exports), or off")
+	hlFlag      = flag.Int("highlight-rating", 0, "highlight photos with at least this star rating (0 to disable)")
+	favFlag     = flag.Int("favorite-rating", 0, "treat photos with at least this star rating as favorites, as well as those with the fav keyword (0 to disable)")
+	takeoutFlag = flag.Bool("process-sidecars", false, "apply Google Takeout JSON sidecars")
+	sideFlag    = flag.Bool("write-sidecars", false, "write changes, such as GPX locations and keywords set in manage mode, to XMP sidecars rather than to originals")
+	stackFlag   = flag.Duration("stack-window", 0, "stack similar photos taken within this time of each other as bursts (e.g. 2s)")
+	rootFlag    = flag.String("root-mode", "merge", "how to combine input directories: merge same-named albums, or keep each separate under its own name")
+	gpxFlag     = flag.String("gpx", "", "comma-separated GPX files or directories of them, to geotag photos without a location and draw tracks on album maps")
+	wgpsFlag    = flag.Bool("write-gps", false, "write locations found in GPX tracks back to originals (or XMP sidecars with -write-sidecars)")
+	stripFlag   = flag.Bool("strip-metadata", false, "publish files with the default metadata policy, keeping only what the site displays plus credits and captions")
 )
 
+// manager serves management requests in -manage mode, and is updated after each build.
+var manager *manage.Server
+
+// subcommands run instead of building the site, as "livstid <subcommand> [flags]".
+var subcommands = map[string]func(c *livstid.Config) error{
+	"audit-metadata": auditMetadata,
+	"dupes":          dupes,
+	"align":          align,
+}
+
 func main() {
 	klog.InitFlags(nil)
-	flag.Parse()
 
-	if len(flag.Args()) == 0 {
-		klog.Exitf("required arguments: directories to process")
+	args := os.Args[1:]
+	sub := ""
+	if len(args) > 0 && subcommands[args[0]] != nil {
+		sub = args[0]
+		args = args[1:]
+	}
+	// flag.ExitOnError is set, so a parse error has already exited
+	_ = flag.CommandLine.Parse(args)
+
+	c, err := config()
+	if err != nil {
+		klog.Exitf("config: %v", err)
 	}
 
-	if *outFlag == "" {
+	if c.OutDir == "" {
 		klog.Exitf("--out is a required flag")
 	}
 
-	c := &livstid.Config{
-		InDirs:       flag.Args(),
-		OutDir:       *outFlag,
-		Collection:   *titleFlag,
-		Description:  *descFlag,
-		RCloneTarget: *rcloneFlag,
-		Thumbnails: map[string]livstid.ThumbOpts{
-			"Tiny":     {Y: 120, Quality: 70},
-			"Album":    {Y: 350, Quality: 80},
-			"Recent":   {X: 512, Quality: 85},
-			"Recent2X": {X: 1024, Quality: 85},
-			"View":     {X: 1920, Quality: 85},
-		},
-		ProcessSidecars: false,
+	if sub != "" {
+		if err := subcommands[sub](c); err != nil {
+			klog.Exitf("%s: %v", sub, err)
+		}
+		return
+	}
+
+	if len(c.InDirs) == 0 && len(c.Roots) == 0 {
+		klog.Exitf("required arguments: directories to process")
 	}
+
 	var wg sync.WaitGroup
 	if *manageFlag {
+		manager = manage.New(c, c.OutDir)
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			serveDynamic(c, *outFlag, *addrFlag)
+			serveDynamic(manager, c.OutDir, *addrFlag)
 		}()
 	} else if *listenFlag {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			serveStatic(*outFlag, *addrFlag)
+			serveStatic(c.OutDir, *addrFlag)
 		}()
 	}
 
@@ -95,6 +133,115 @@ func main() {
 	wg.Wait()
 }
 
+// config builds the configuration from flag defaults, an optional config file,
+// and explicitly set flags, in increasing order of precedence.
+func config() (*livstid.Config, error) {
+	c := &livstid.Config{
+		InDirs:          flag.Args(),
+		OutDir:          *outFlag,
+		Collection:      *titleFlag,
+		Description:     *descFlag,
+		RCloneTarget:    *rcloneFlag,
+		CacheDir:        *cacheFlag,
+		RebuildCache:    *rebuildFlag,
+		Workers:         *workersFlag,
+		TranscodeVideos: *videoFlag,
+		Gazetteer:       *gazFlag,
+		GPSPolicy:       *gpsFlag,
+		EventGap:        *eventFlag,
+		IndexSort:       *sortFlag,
+		DisplayTimezone: *tzFlag,
+		RootMode:        *rootFlag,
+		Dedupe:          *dedupeFlag,
+		StackWindow:     *stackFlag,
+		HighlightRating: *hlFlag,
+		WriteSidecars:   *sideFlag,
+		Thumbnails: map[string]livstid.ThumbOpts{
+			"Tiny":     {Y: 120, Quality: 70},
+			"Album":    {Y: 350, Quality: 80},
+			"Recent":   {X: 512, Quality: 85},
+			"Recent2X": {X: 1024, Quality: 85},
+			"View":     {X: 1920, Quality: 85},
+		},
+		ProcessSidecars: *takeoutFlag,
+		WriteGPS:        *wgpsFlag,
+	}
+
+	if *gpxFlag != "" {
+		c.GPX = strings.Split(*gpxFlag, ",")
+	}
+
+	if *stripFlag {
+		setStripMetadata(c, true)
+	}
+
+	if *favFlag > 0 {
+		setFavoriteRating(c, *favFlag)
+	}
+
+	if *configFlag == "" {
+		return c, nil
+	}
+
+	if err := livstid.LoadConfig(*configFlag, c); err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]func(){
+		"out":              func() { c.OutDir = *outFlag },
+		"title":            func() { c.Collection = *titleFlag },
+		"description":      func() { c.Description = *descFlag },
+		"rclone":           func() { c.RCloneTarget = *rcloneFlag },
+		"cache-dir":        func() { c.CacheDir = *cacheFlag },
+		"workers":          func() { c.Workers = *workersFlag },
+		"transcode-video":  func() { c.TranscodeVideos = *videoFlag },
+		"gazetteer":        func() { c.Gazetteer = *gazFlag },
+		"gps-policy":       func() { c.GPSPolicy = *gpsFlag },
+		"event-gap":        func() { c.EventGap = *eventFlag },
+		"index-sort":       func() { c.IndexSort = *sortFlag },
+		"display-timezone": func() { c.DisplayTimezone = *tzFlag },
+		"root-mode":        func() { c.RootMode = *rootFlag },
+		"dedupe":           func() { c.Dedupe = *dedupeFlag },
+		"stack-window":     func() { c.StackWindow = *stackFlag },
+		"highlight-rating": func() { c.HighlightRating = *hlFlag },
+		"write-sidecars":   func() { c.WriteSidecars = *sideFlag },
+		"process-sidecars": func() { c.ProcessSidecars = *takeoutFlag },
+		"favorite-rating":  func() { setFavoriteRating(c, *favFlag) },
+		"strip-metadata":   func() { setStripMetadata(c, *stripFlag) },
+		"gpx":              func() { c.GPX = strings.Split(*gpxFlag, ",") },
+		"write-gps":        func() { c.WriteGPS = *wgpsFlag },
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if o, ok := overrides[f.Name]; ok {
+			o()
+		}
+	})
+
+	if len(flag.Args()) > 0 {
+		c.InDirs = flag.Args()
+	}
+	return c, nil
+}
+
+// setFavoriteRating adds a minimum star rating to the favorite rule.
+func setFavoriteRating(c *livstid.Config, rating int) {
+	if c.Favorites == nil {
+		r := livstid.DefaultFavoriteRule
+		c.Favorites = &r
+	}
+	c.Favorites.MinRating = rating
+}
+
+// setStripMetadata sets the default metadata policy, or removes any policy if strip is false.
+func setStripMetadata(c *livstid.Config, strip bool) {
+	if !strip {
+		c.Metadata = nil
+		return
+	}
+	p := livstid.DefaultMetadataPolicy
+	c.Metadata = &p
+}
+
 // build collects, renders, and syncs.
 func build(c *livstid.Config) (*livstid.Assembly, error) {
 	a, err := livstid.Collect(c)
@@ -113,6 +260,10 @@ func build(c *livstid.Config) (*livstid.Assembly, error) {
 		return a, fmt.Errorf("render: %w", err)
 	}
 
+	if manager != nil {
+		manager.SetAssembly(a)
+	}
+
 	if c.RCloneTarget != "" {
 		if err := rcloneSync(c); err != nil {
 			return a, fmt.Errorf("clone: %w", err)
@@ -122,6 +273,83 @@ func build(c *livstid.Config) (*livstid.Assembly, error) {
 	return a, nil
 }
 
+// auditMetadata reports published files which carry metadata the configuration doesn't allow.
+func auditMetadata(c *livstid.Config) error {
+	vs, err := livstid.AuditMetadata(c)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vs {
+		fmt.Println(v)
+	}
+
+	if len(vs) > 0 {
+		return fmt.Errorf("found %d metadata violations in %s", len(vs), c.OutDir)
+	}
+	klog.Infof("no metadata violations found in %s", c.OutDir)
+	return nil
+}
+
+// dupes reports clusters of duplicate photos within the input directories, and the copy published from each.
+func dupes(c *livstid.Config) error {
+	if len(c.InDirs) == 0 && len(c.Roots) == 0 {
+		return errors.New("required arguments: directories to process")
+	}
+
+	dcs, err := livstid.FindDupes(c)
+	if err != nil {
+		return err
+	}
+
+	for _, dc := range dcs {
+		match := "identical"
+		if dc.Distance > 0 {
+			match = fmt.Sprintf("distance %d", dc.Distance)
+		}
+		fmt.Printf("%s (kept, %dx%d, %s)\n", dc.Best.InPath, dc.Best.Width, dc.Best.Height, match)
+		for _, i := range dc.Others {
+			fmt.Printf("  %s (%dx%d)\n", i.InPath, i.Width, i.Height)
+		}
+	}
+
+	klog.Infof("found %d clusters of duplicate photos", len(dcs))
+	return nil
+}
+
+// align suggests clock corrections for cameras whose clocks disagree with the camera with the most photos.
+func align(c *livstid.Config) error {
+	if len(c.InDirs) == 0 && len(c.Roots) == 0 {
+		return errors.New("required arguments: directories to process")
+	}
+
+	ss, err := livstid.SuggestClockOffsets(c)
+	if err != nil {
+		return err
+	}
+	if len(ss) == 0 {
+		klog.Infof("no clock corrections to suggest")
+		return nil
+	}
+
+	for _, s := range ss {
+		fmt.Println(s)
+	}
+
+	// offsets are suggested after applying the configured corrections, so print their sum
+	fmt.Println("\n# offsets include any configured correction; replace existing entries for these cameras")
+	fmt.Println("clock_corrections:")
+	for _, s := range ss {
+		cc := s.Correction()
+		fmt.Printf("  - make: %q\n    model: %q\n", cc.Make, cc.Model)
+		if cc.Serial != "" {
+			fmt.Printf("    serial: %q\n", cc.Serial)
+		}
+		fmt.Printf("    offset: %s\n", cc.Offset)
+	}
+	return nil
+}
+
 // rcloneSync synchronizes the website to a remote crlone target.
 func rcloneSync(c *livstid.Config) error {
 	klog.Infof("rclone syncing to %s ...", c.RCloneTarget)
@@ -133,7 +361,7 @@ func rcloneSync(c *livstid.Config) error {
 	start := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, path, "sync", c.OutDir+"/", c.RCloneTarget+"/")
+	cmd := exec.CommandContext(ctx, path, "sync", "--exclude", "/"+livstid.CacheDirName+"/**", c.OutDir+"/", c.RCloneTarget+"/")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("%v failed: %w", cmd, err)
 	}
@@ -141,10 +369,23 @@ func rcloneSync(c *livstid.Config) error {
 	return nil
 }
 
+// hideDotfiles refuses to serve hidden files, such as the metadata cache.
+func hideDotfiles(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(p, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // serveStatic serves a static web directory via HTTP.
 func serveStatic(path string, addr string) {
 	fs := http.FileServer(http.Dir(path))
-	http.Handle("/", fs)
+	http.Handle("/", hideDotfiles(fs))
 
 	klog.Infof("Listening on %s...", addr)
 	server := &http.Server{
@@ -161,11 +402,11 @@ func serveStatic(path string, addr string) {
 }
 
 // serveDynamic serves a dynamic website with management enabled.
-func serveDynamic(c *livstid.Config, path string, addr string) {
-	m := manage.New(c, path)
+func serveDynamic(m *manage.Server, path string, addr string) {
 	fs := http.FileServer(http.Dir(path))
-	http.Handle("/", fs)
+	http.Handle("/", hideDotfiles(fs))
 	http.HandleFunc("/hide", m.HideHandler())
+	http.HandleFunc("/keywords", m.KeywordsHandler())
 	server := &http.Server{
 		Addr:         addr,
 		Handler:      nil,
@@ -203,6 +444,8 @@ func watch(c *livstid.Config, a *livstid.Assembly) error {
 				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) ||
 					event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
 					klog.Infof("watch event: %s", event)
+					// the cache only needs to be rebuilt once
+					c.RebuildCache = false
 					assembly, err := build(c)
 					if err != nil {
 						klog.Exitf("build failed: %v", err)
@@ -236,7 +479,15 @@ func updateWatchPaths(c *livstid.Config, w *fsnotify.Watcher, a *livstid.Assembl
 		exists[d] = true
 	}
 
-	dirs := c.InDirs
+	roots, err := c.InputRoots()
+	if err != nil {
+		return fmt.Errorf("roots: %w", err)
+	}
+
+	dirs := []string{}
+	for _, r := range roots {
+		dirs = append(dirs, r.Path)
+	}
 	if path != "" {
 		dirs = append(dirs, path)
 	}
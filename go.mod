@@ -8,8 +8,10 @@ require (
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/karrick/godirwalk v1.17.0
 	github.com/otiai10/copy v1.14.1
+	golang.org/x/image v0.29.0
 	google.golang.org/api v0.244.0
 	google.golang.org/genai v1.18.0
+	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/klog/v2 v2.130.1
 )
 
@@ -33,7 +35,6 @@ require (
 	go.opentelemetry.io/otel/metric v1.37.0 // indirect
 	go.opentelemetry.io/otel/trace v1.37.0 // indirect
 	golang.org/x/crypto v0.40.0 // indirect
-	golang.org/x/image v0.29.0 // indirect
 	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
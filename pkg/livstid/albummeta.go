@@ -0,0 +1,141 @@
+package livstid
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+)
+
+// AlbumMetaName is the name of the optional album metadata file within a source directory.
+var AlbumMetaName = "album.yaml"
+
+// Album sort orders.
+const (
+	SortTaken     = "taken"
+	SortTakenDesc = "taken-desc"
+	SortName      = "name"
+	SortNameDesc  = "name-desc"
+)
+
+// AlbumMeta is optional album metadata, read from an album.yaml file.
+type AlbumMeta struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// Cover is the file name of the image which represents the album.
+	Cover string `yaml:"cover"`
+	// Sort is the order of images: "taken" (default), "taken-desc", "name" or "name-desc".
+	Sort string `yaml:"sort"`
+	// Hidden albums are unlisted: they are still published, but are left out of the index, and their
+	// photos are left out of every other album.
+	Hidden bool `yaml:"hidden"`
+	// Start and End override the date range of the album.
+	Start time.Time `yaml:"start"`
+	End   time.Time `yaml:"end"`
+	// Timezone is the IANA time zone, such as "Asia/Tokyo", that the album's photos were taken in.
+	// Capture times recorded in another zone are converted to it, and those which record no zone
+	// are assumed to be in it.
+	Timezone string `yaml:"timezone"`
+
+	location *time.Location
+}
+
+// readAlbumMeta reads the album metadata file within dir, returning nil if there is none.
+func readAlbumMeta(dir string) (*AlbumMeta, error) {
+	path := filepath.Join(dir, AlbumMetaName)
+	bs, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	m := &AlbumMeta{}
+	d := yaml.NewDecoder(bytes.NewReader(bs))
+	d.KnownFields(true)
+	if err := d.Decode(m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	switch m.Sort {
+	case "", SortTaken, SortTakenDesc, SortName, SortNameDesc:
+	default:
+		return nil, fmt.Errorf("%s: unknown sort order %q", path, m.Sort)
+	}
+
+	if m.Timezone != "" {
+		loc, err := time.LoadLocation(m.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%s: timezone: %w", path, err)
+		}
+		m.location = loc
+	}
+	return m, nil
+}
+
+// applyMeta sets album fields from its metadata file. It is called before any images are added.
+func (a *Album) applyMeta(m *AlbumMeta) {
+	a.meta = m
+	if m == nil {
+		return
+	}
+	if m.Title != "" {
+		a.Title = m.Title
+	}
+	a.Description = m.Description
+	a.Hidden = m.Hidden
+}
+
+// finishMeta applies the parts of the album metadata which depend on the album's images.
+func (a *Album) finishMeta() {
+	m := a.meta
+	if m == nil {
+		sortImages(a.Images, SortTaken)
+		return
+	}
+
+	sortImages(a.Images, m.Sort)
+
+	if !m.Start.IsZero() {
+		a.StartTime = m.Start
+	}
+	if !m.End.IsZero() {
+		a.EndTime = m.End
+	}
+
+	if m.Cover == "" {
+		return
+	}
+	for _, i := range a.Images {
+		if strings.EqualFold(filepath.Base(i.InPath), m.Cover) || i.BasePath == urlSafePath(m.Cover) {
+			a.Cover = i
+			return
+		}
+	}
+	klog.Warningf("%s: cover %q not found", a.InPath, m.Cover)
+}
+
+// sortImages sorts images in the given order.
+func sortImages(is []*Image, order string) {
+	sort.SliceStable(is, func(i, j int) bool {
+		switch order {
+		case SortTakenDesc:
+			return is[i].Taken.After(is[j].Taken)
+		case SortName:
+			return is[i].BasePath < is[j].BasePath
+		case SortNameDesc:
+			return is[i].BasePath > is[j].BasePath
+		default:
+			return is[i].Taken.Before(is[j].Taken)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package livstid
+
+import (
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// maxClockSkew is the largest difference between camera clocks that is considered.
+	maxClockSkew = 24 * time.Hour
+	// alignThreshold is the number of perceptual hash bits in which photos of the same scene by different cameras may differ.
+	alignThreshold = 10
+	// alignDistance is the distance in meters within which photos by different cameras are assumed to be taken together.
+	alignDistance = 30.0
+	// alignWindow is the largest clock difference suggested by photos taken close together, as location
+	// alone can't tell a wrong clock from a later visit to the same place.
+	alignWindow = time.Hour
+	// similarWeight is the number of votes for an offset given by a visually similar photo pair,
+	// rather than the one given by a pair taken close together.
+	similarWeight = 4
+	// minAlignMatches is the number of agreeing photo pairs needed to suggest an offset.
+	minAlignMatches = 3
+)
+
+// Camera identifies the camera which took a photo.
+type Camera struct {
+	Make   string
+	Model  string
+	Serial string
+}
+
+func (c Camera) String() string {
+	s := strings.TrimSpace(c.Make + " " + c.Model)
+	if c.Serial != "" {
+		s += " #" + c.Serial
+	}
+	return s
+}
+
+// ClockSuggestion is a suggested clock correction for a camera, relative to a reference camera.
+type ClockSuggestion struct {
+	Camera    Camera
+	Reference Camera
+	// Offset is the correction to add to the camera's capture times, after Applied.
+	Offset time.Duration
+	// Applied is the clock correction already configured for most of the camera's photos, if any.
+	Applied time.Duration
+	// Similar is the number of visually similar photo pairs which agree on the offset.
+	Similar int
+	// Nearby is the number of photo pairs taken close together which agree on the offset.
+	Nearby int
+}
+
+// Correction returns the suggestion as a clock correction, combined with the one already applied.
+func (s ClockSuggestion) Correction() ClockCorrection {
+	return ClockCorrection{Make: s.Camera.Make, Model: s.Camera.Model, Serial: s.Camera.Serial, Offset: s.Applied + s.Offset}
+}
+
+// alignMatch is the difference in capture time between photos by two cameras which appear to be taken together.
+type alignMatch struct {
+	offset  time.Duration
+	similar bool
+}
+
+// SuggestClockOffsets compares the photos of each camera with those of the camera with the most
+// photos, suggesting offsets for cameras whose clocks disagree with it. Photos are paired within
+// an album if they are visually similar or were taken close together, and the most common
+// difference in capture time is suggested, with visually similar pairs counting for more.
+// Existing clock corrections are applied first.
+func SuggestClockOffsets(c *Config) ([]ClockSuggestion, error) {
+	if err := validateClockCorrections(c); err != nil {
+		return nil, err
+	}
+
+	is, mc, err := loadImages(c)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[Camera]map[time.Duration]int{}
+	for _, i := range is {
+		off := time.Duration(0)
+		if n := clockCorrection(i, c.ClockCorrections); n >= 0 {
+			off = c.ClockCorrections[n].Offset
+		}
+		cam := cameraOf(i)
+		if applied[cam] == nil {
+			applied[cam] = map[time.Duration]int{}
+		}
+		applied[cam][off]++
+	}
+	applyClockCorrections(is, c)
+	hashImages(is, false, true, c.Workers, mc)
+
+	if err := mc.Save(); err != nil {
+		klog.Errorf("unable to save metadata cache: %v", err)
+	}
+
+	counts := map[Camera]int{}
+	albums := map[string][]*Image{}
+	for _, i := range is {
+		if i.Model == "" || i.Taken.IsZero() {
+			continue
+		}
+		counts[cameraOf(i)]++
+		albums[filepath.Dir(i.InPath)] = append(albums[filepath.Dir(i.InPath)], i)
+	}
+
+	cams := []Camera{}
+	for cam := range counts {
+		cams = append(cams, cam)
+	}
+	if len(cams) < 2 {
+		return nil, nil
+	}
+	sort.Slice(cams, func(i, j int) bool {
+		if counts[cams[i]] != counts[cams[j]] {
+			return counts[cams[i]] > counts[cams[j]]
+		}
+		return cams[i].String() < cams[j].String()
+	})
+	ref := cams[0]
+	klog.Infof("aligning %d cameras to %s (%d photos)", len(cams)-1, ref, counts[ref])
+
+	matches := map[Camera][]alignMatch{}
+	for _, ais := range albums {
+		for _, r := range ais {
+			if cameraOf(r) != ref {
+				continue
+			}
+			for _, i := range ais {
+				cam := cameraOf(i)
+				if cam == ref {
+					continue
+				}
+				if m, ok := alignPair(r, i); ok {
+					matches[cam] = append(matches[cam], m)
+				}
+			}
+		}
+	}
+
+	ss := []ClockSuggestion{}
+	for _, cam := range cams[1:] {
+		s, ok := suggestOffset(matches[cam])
+		if !ok {
+			klog.Infof("%s: not enough photos in common with %s", cam, ref)
+			continue
+		}
+		s.Camera = cam
+		s.Reference = ref
+		for off, n := range applied[cam] {
+			if n > applied[cam][s.Applied] || (n == applied[cam][s.Applied] && off.Abs() < s.Applied.Abs()) {
+				s.Applied = off
+			}
+		}
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// cameraOf returns the camera which took an image.
+func cameraOf(i *Image) Camera {
+	return Camera{Make: i.Make, Model: i.Model, Serial: i.Serial}
+}
+
+// alignPair returns the offset to add to the capture time of i to match ref, if they appear to be
+// photos of the same scene, or were taken at the same place within alignWindow.
+func alignPair(ref, i *Image) (alignMatch, bool) {
+	off := ref.Taken.Sub(i.Taken)
+	if off < -maxClockSkew || off > maxClockSkew {
+		return alignMatch{}, false
+	}
+
+	if ref.DHash != "" && i.DHash != "" && bits.OnesCount64(parseDHash(ref.DHash)^parseDHash(i.DHash)) <= alignThreshold {
+		return alignMatch{offset: off, similar: true}, true
+	}
+	if off.Abs() <= alignWindow && ref.GPS != nil && i.GPS != nil && ref.GPS.Distance(i.GPS) <= alignDistance {
+		return alignMatch{offset: off}, true
+	}
+	return alignMatch{}, false
+}
+
+// suggestOffset returns the offset among matches with the most votes, to the nearest minute, refined
+// to the median of the matches which agree with it.
+func suggestOffset(ms []alignMatch) (ClockSuggestion, bool) {
+	votes := map[time.Duration]int{}
+	pairs := map[time.Duration]int{}
+	for _, m := range ms {
+		off := m.offset.Round(time.Minute)
+		pairs[off]++
+		votes[off]++
+		if m.similar {
+			votes[off] += similarWeight - 1
+		}
+	}
+
+	var best time.Duration
+	bestVotes := 0
+	for off, n := range votes {
+		if n > bestVotes || (n == bestVotes && off.Abs() < best.Abs()) {
+			best, bestVotes = off, n
+		}
+	}
+	if pairs[best] < minAlignMatches {
+		return ClockSuggestion{}, false
+	}
+
+	s := ClockSuggestion{}
+	agree := []time.Duration{}
+	for _, m := range ms {
+		if (m.offset - best).Abs() > time.Minute {
+			continue
+		}
+		agree = append(agree, m.offset)
+		if m.similar {
+			s.Similar++
+		} else {
+			s.Nearby++
+		}
+	}
+
+	sort.Slice(agree, func(i, j int) bool { return agree[i] < agree[j] })
+	s.Offset = agree[len(agree)/2].Round(time.Second)
+	return s, true
+}
+
+// String describes the suggestion.
+func (s ClockSuggestion) String() string {
+	d := fmt.Sprintf("%s: %s relative to %s (%d similar photos, %d nearby)", s.Camera, s.Offset, s.Reference, s.Similar, s.Nearby)
+	if s.Applied != 0 {
+		d += fmt.Sprintf(", after the configured correction of %s", s.Applied)
+	}
+	return d
+}
@@ -9,18 +9,24 @@ import (
 	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"k8s.io/klog/v2"
 )
 
+// Index sort orders.
+const (
+	IndexSortPath = "path"
+	IndexSortDate = "date"
+)
+
 var (
-	favKeyword          = "fav"
-	maxAlbum            = 30
-	maxHierAlbum        = 60
-	maxTopHierAlbum     = 500
-	minAlbumSize        = 4
-	entityChar          = regexp.MustCompile(`%[0-9A-Fa-f]{2,4}`)
-	multipleUnderscores = regexp.MustCompile(`_{2,}`)
+	favKeyword           = "fav"
+	defaultAlbumPageSize = 30
+	defaultRecentSize    = 30
+	defaultMinAlbumSize  = 4
+	entityChar           = regexp.MustCompile(`%[0-9A-Fa-f]{2,4}`)
+	multipleUnderscores  = regexp.MustCompile(`_{2,}`)
 )
 
 // Assembly is an assembled collection of images.
@@ -31,6 +37,18 @@ type Assembly struct {
 	HierAlbums []*Album
 	Favorites  []*Album
 	TagAlbums  []*Album
+	// PlaceAlbums are virtual albums of geocoded photos, sorted by country and city.
+	PlaceAlbums []*Album
+	// EventAlbums are virtual albums of events detected within directory albums.
+	EventAlbums []*Album
+	// OnThisDay holds photos taken on the day of the build in previous years, if any.
+	OnThisDay *Album
+	// YearAlbums are "year in review" albums, newest first.
+	YearAlbums []*Album
+	// Tree arranges Albums and HierAlbums by directory, in the order of Albums.
+	Tree *AlbumNode
+	// Errors are per-image failures encountered during collection.
+	Errors []error
 }
 
 func urlSafePath(in string) string {
@@ -55,37 +73,146 @@ func urlSafePath(in string) string {
 
 // Collect collects an assembly of photos.
 func Collect(c *Config) (*Assembly, error) {
-	is, err := findImages(c.InDirs, c.ProcessSidecars)
+	switch c.IndexSort {
+	case "", IndexSortPath, IndexSortDate:
+	default:
+		return nil, fmt.Errorf("unknown index sort order %q (want %s or %s)", c.IndexSort, IndexSortPath, IndexSortDate)
+	}
+
+	loc, err := displayLocation(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateClockCorrections(c); err != nil {
+		return nil, err
+	}
+
+	if err := validatePrivacy(c); err != nil {
+		return nil, fmt.Errorf("privacy: %w", err)
+	}
+
+	cache := cacheDir(c)
+	mc, err := LoadMetaCache(cache, c.RebuildCache)
+	if err != nil {
+		return nil, fmt.Errorf("load metadata cache: %w", err)
+	}
+
+	roots, err := c.InputRoots()
+	if err != nil {
+		return nil, fmt.Errorf("roots: %w", err)
+	}
+
+	is, err := findImages(roots, c.ProcessSidecars, mc)
 	if err != nil {
 		return nil, err
 	}
+	applyClockCorrections(is, c)
+	zoneTimes(is, loc)
+
+	tracks, err := LoadTracks(c.GPX)
+	if err != nil {
+		return nil, fmt.Errorf("tracks: %w", err)
+	}
+	tagged := geotagFromTracks(is, tracks, loc, c)
+	var gpsErrs []error
+	if c.WriteGPS {
+		// errors are reported by Validate
+		gpsErrs = writeGPS(tagged, c.WriteSidecars)
+	}
+	tracks = trackPrivacy(tracks, c)
+
+	applyCuration(is, c)
+	is, _, err = dedupe(is, c.Dedupe, c, mc)
+	if err != nil {
+		return nil, fmt.Errorf("dedupe: %w", err)
+	}
+	if c.StackWindow > 0 {
+		hashImages(is, false, true, c.Workers, mc)
+	}
+	// collisions are reported by Validate
+	is, collisions := removeCollisions(is, c)
+
+	if err := mc.Save(); err != nil {
+		klog.Errorf("unable to save metadata cache: %v", err)
+	}
+
+	// places are named from exact locations, which the privacy settings then coarsen or remove
+	if err := geocode(is, c); err != nil {
+		return nil, fmt.Errorf("geocode: %w", err)
+	}
+
+	if err := applyPrivacy(is, c); err != nil {
+		return nil, fmt.Errorf("privacy: %w", err)
+	}
 
 	albums := map[string]*Album{}
 	hierAlbums := map[string]*Album{}
 	favAlbums := map[string]*Album{}
 	tagAlbums := map[string]*Album{}
+	placeAlbums := map[string]*Album{}
 
-	for _, i := range is {
-		klog.V(1).Infof("build image: %+v", i)
-		if len(c.Thumbnails) > 0 {
-			i.Resize, err = thumbnails(i, c.Thumbnails, c.OutDir)
-			if err != nil {
-				return nil, fmt.Errorf("thumbnails: %w", err)
-			}
+	errs := append(gpsErrs, collisions...)
+	if len(c.Thumbnails) > 0 {
+		republish, err := policyChanged(c, cache)
+		if err != nil {
+			return nil, fmt.Errorf("publish policy: %w", err)
+		}
+		var terrs []error
+		is, terrs = generateThumbnails(is, c, republish)
+		errs = append(errs, terrs...)
+		// files which failed to publish were removed, so are published with the new policy next time
+		if err := savePolicy(c, cache); err != nil {
+			return nil, fmt.Errorf("publish policy: %w", err)
 		}
+	}
 
-		if err := processImage(i, c.OutDir, albums, hierAlbums, favAlbums, tagAlbums); err != nil {
+	for _, i := range is {
+		if err := processImage(i, c, albums, hierAlbums, favAlbums, tagAlbums, placeAlbums); err != nil {
 			continue
 		}
 	}
 
-	return buildAssembly(is, albums, hierAlbums, favAlbums, tagAlbums, c.OutDir)
+	localizeTimes(is, loc)
+	attachTracks(albums, tracks, loc, c)
+
+	errs = append(errs, albumCollisions(albums)...)
+	if len(roots) > 1 && c.RootMode != RootsSeparate {
+		logMergedAlbums(albums)
+	}
+
+	a, err := buildAssembly(is, albums, hierAlbums, favAlbums, tagAlbums, placeAlbums, c)
+	if err != nil {
+		return nil, err
+	}
+	a.Errors = errs
+	return a, nil
+}
+
+// loadImages finds the images within the input directories for commands which don't build the
+// site, returning the metadata cache for the caller to save.
+func loadImages(c *Config) ([]*Image, *MetaCache, error) {
+	roots, err := c.InputRoots()
+	if err != nil {
+		return nil, nil, fmt.Errorf("roots: %w", err)
+	}
+
+	mc, err := LoadMetaCache(cacheDir(c), c.RebuildCache)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load metadata cache: %w", err)
+	}
+
+	is, err := findImages(roots, c.ProcessSidecars, mc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return is, mc, nil
 }
 
-func findImages(dirs []string, processSidecars bool) ([]*Image, error) {
+func findImages(roots []Root, processSidecars bool, mc *MetaCache) ([]*Image, error) {
 	is := []*Image{}
-	for _, d := range dirs {
-		fs, err := Find(d, processSidecars)
+	for _, r := range roots {
+		fs, err := Find(r, processSidecars, mc)
 		if err != nil {
 			return nil, fmt.Errorf("find: %w", err)
 		}
@@ -94,41 +221,59 @@ func findImages(dirs []string, processSidecars bool) ([]*Image, error) {
 	return is, nil
 }
 
-func processImage(i *Image, outDir string, albums, hierAlbums, favAlbums, tagAlbums map[string]*Album) error {
+func processImage(i *Image, c *Config, albums, hierAlbums, favAlbums, tagAlbums, placeAlbums map[string]*Album) error {
+	outDir := c.OutDir
 	albumDir := filepath.Dir(i.InPath)
-	safeRelPath := urlSafePath(i.RelPath)
 	rd := filepath.Dir(i.RelPath)
-	i.OutPath = filepath.Join(outDir, safeRelPath)
+	i.OutPath = filepath.Join(outDir, publishRelPath(i, c))
 	hier := strings.Split(rd, string(filepath.Separator))
 	if filepath.Base(rd) == "EmptyName" {
 		klog.Infof("skipping EmptyName ...")
 		return errors.New("skip")
 	}
 
-	// Add to regular albums
-	if albums[rd] == nil {
-		albums[rd] = &Album{
-			InPath:  albumDir,
-			RelPath: rd,
-			OutPath: filepath.Join(outDir, urlSafePath(rd)),
-			Images:  []*Image{},
-			Title:   filepath.Base(rd),
-			Hier:    hier,
+	// Add to regular albums. The album of photos at the top of an input directory would replace
+	// the index page, so they are left out unless TopLevelAlbum gives them a directory of their own.
+	if rd == "." {
+		klog.Warningf("%s is not in any album: move it into a directory, or set top_level_album", i.InPath)
+	} else {
+		if albums[rd] == nil {
+			albums[rd] = &Album{
+				InPath:  albumDir,
+				RelPath: rd,
+				OutPath: filepath.Join(outDir, urlSafePath(rd)),
+				Images:  []*Image{},
+				Title:   filepath.Base(rd),
+				Hier:    hier,
+			}
+
+			m, err := readAlbumMeta(albumDir)
+			if err != nil {
+				klog.Errorf("album metadata: %v", err)
+			}
+			albums[rd].applyMeta(m)
+		}
+		albums[rd].Images = append(albums[rd].Images, i)
+		// photos of hidden albums are only reachable through the album itself
+		if albums[rd].Hidden {
+			return nil
 		}
 	}
-	albums[rd].Images = append(albums[rd].Images, i)
 
 	// Add to hierarchy albums
 	addToHierAlbums(i, hier, hierAlbums, albumDir, outDir)
 
 	// Add to favorite albums
-	if slices.Contains(i.Keywords, favKeyword) {
+	if i.Favorite {
 		addToFavAlbums(i, favAlbums, rd, outDir)
 	}
 
 	// Add to tag albums
 	addToTagAlbums(i, tagAlbums, rd, outDir)
 
+	// Add to place albums
+	addToPlaceAlbums(i, placeAlbums, rd, outDir)
+
 	return nil
 }
 
@@ -139,33 +284,28 @@ func addToHierAlbums(i *Image, hier []string, hierAlbums map[string]*Album, albu
 		}
 		valbum := strings.Join(hier[0:level], "/")
 		if hierAlbums[valbum] == nil {
+			inPath := albumDir
+			for range len(hier) - level {
+				inPath = filepath.Dir(inPath)
+			}
 			hierAlbums[valbum] = &Album{
-				InPath:    filepath.Join(filepath.Dir(albumDir), valbum),
-				OutPath:   filepath.Join(outDir, valbum),
+				InPath:    inPath,
+				RelPath:   valbum,
+				OutPath:   filepath.Join(outDir, urlSafePath(valbum)),
 				Images:    []*Image{},
-				Title:     valbum,
+				Title:     hier[level-1],
 				Hier:      strings.Split(valbum, string(filepath.Separator)),
 				HierLevel: level,
 			}
 		}
-
-		// Enforce hierarchical limits
-		maxImages := maxHierAlbum
-		if level == 1 {
-			maxImages = maxTopHierAlbum
-		}
-
-		// Only add image if we haven't reached the limit
-		if len(hierAlbums[valbum].Images) < maxImages {
-			hierAlbums[valbum].Images = append(hierAlbums[valbum].Images, i)
-		}
+		hierAlbums[valbum].Images = append(hierAlbums[valbum].Images, i)
 	}
 }
 
 func addToFavAlbums(i *Image, favAlbums map[string]*Album, rd, outDir string) {
-	for _, k := range i.Keywords {
+	for _, k := range append([]string{"all"}, i.Keywords...) {
 		if k == favKeyword {
-			k = "all"
+			continue
 		}
 
 		if favAlbums[k] == nil {
@@ -183,7 +323,14 @@ func addToFavAlbums(i *Image, favAlbums map[string]*Album, rd, outDir string) {
 }
 
 func addToTagAlbums(i *Image, tagAlbums map[string]*Album, rd, outDir string) {
-	for _, k := range i.Keywords {
+	ks := slices.Clone(i.Keywords)
+	for _, t := range i.Place.Tags() {
+		if !slices.Contains(ks, t) {
+			ks = append(ks, t)
+		}
+	}
+
+	for _, k := range ks {
 		if tagAlbums[k] == nil {
 			tagAlbums[k] = &Album{
 				InPath:  rd,
@@ -197,38 +344,247 @@ func addToTagAlbums(i *Image, tagAlbums map[string]*Album, rd, outDir string) {
 	}
 }
 
+func addToPlaceAlbums(i *Image, placeAlbums map[string]*Album, rd, outDir string) {
+	if i.Place == nil || i.Place.City == "" {
+		return
+	}
+
+	country := i.Place.Country
+	// same-named cities in different regions of a country are different places
+	key := country + "/" + i.Place.AdminCode + "/" + i.Place.City
+	if placeAlbums[key] == nil {
+		placeAlbums[key] = &Album{
+			InPath:      rd,
+			OutPath:     filepath.Join(outDir, "places", urlSafePath(country), urlSafePath(i.Place.City)),
+			Images:      []*Image{},
+			Title:       i.Place.City,
+			Description: i.Place.String(),
+			Hier:        []string{"places", country, i.Place.City},
+		}
+	}
+	placeAlbums[key].Images = append(placeAlbums[key].Images, i)
+}
+
+// disambiguatePlaces adds the region to the paths and titles of place albums for same-named
+// cities within a country, which would otherwise be published to the same path.
+func disambiguatePlaces(ps []*Album) {
+	byPath := map[string][]*Album{}
+	for _, a := range ps {
+		byPath[a.OutPath] = append(byPath[a.OutPath], a)
+	}
+
+	for _, same := range byPath {
+		if len(same) < 2 {
+			continue
+		}
+		for _, a := range same {
+			p := a.Images[0].Place
+			region := p.Region
+			if region == "" {
+				region = p.AdminCode
+			}
+			a.Title = p.City + ", " + region
+			a.OutPath = filepath.Join(filepath.Dir(a.OutPath), urlSafePath(a.Title))
+			a.Hier = []string{"places", p.Country, a.Title}
+		}
+	}
+}
+
 func buildAssembly(
 	is []*Image,
-	albums, hierAlbums, favAlbums, tagAlbums map[string]*Album,
-	outDir string,
+	albums, hierAlbums, favAlbums, tagAlbums, placeAlbums map[string]*Album,
+	c *Config,
 ) (*Assembly, error) {
-	as := filterAndSortAlbums(albums)
-	fs := filterAlbumsBySize(favAlbums)
-	ts := filterAlbumsBySize(tagAlbums)
+	roots, err := c.InputRoots()
+	if err != nil {
+		return nil, fmt.Errorf("roots: %w", err)
+	}
+
+	minSize := orDefault(c.MinAlbumSize, defaultMinAlbumSize)
+	as := filterAndSortAlbums(albums, minSize)
+	fs := filterAlbumsBySize(favAlbums, minSize)
+	ts := filterAlbumsBySize(tagAlbums, minSize)
+	ps := filterAlbumsBySize(placeAlbums, minSize)
+	disambiguatePlaces(ps)
 	hs := albumsToSlice(hierAlbums)
-	recent := createRecentAlbum(is, outDir)
+	es := detectEvents(listedAlbums(as), c, minSize)
+	listed := listedImages(is, as)
+	ys := createYearAlbums(listed, c, minSize)
+	onThisDay := createOnThisDayAlbum(listed, c.OutDir, time.Now())
+	recent := createRecentAlbum(listed, c.OutDir, orDefault(c.RecentSize, defaultRecentSize))
+
+	sort.Slice(ps, func(i, j int) bool {
+		return strings.Join(ps[i].Hier, "/") < strings.Join(ps[j].Hier, "/")
+	})
+
+	size := orDefault(c.AlbumPageSize, defaultAlbumPageSize)
+	for _, group := range [][]*Album{as, fs, ts, ps, es, ys} {
+		for _, a := range group {
+			a.PageSize = size
+		}
+	}
+	if onThisDay != nil {
+		onThisDay.PageSize = size
+		onThisDay.updateTimes()
+	}
+
+	for _, group := range [][]*Album{as, fs, ts, ps, hs, es, ys, {recent}} {
+		for _, a := range group {
+			a.updateTimes()
+		}
+	}
+
+	for _, group := range [][]*Album{as, es} {
+		for _, a := range group {
+			stackBursts(a, c)
+		}
+	}
+
+	assignPages(as)
+
+	labels := rootLabels(roots)
+	applyLabels(as, labels, 0)
+	applyLabels(hs, labels, 0)
+	applyLabels(es, labels, 1)
+
+	if c.IndexSort == IndexSortDate {
+		sortAlbumsByDate(as)
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		return hs[i].RelPath < hs[j].RelPath
+	})
 
 	return &Assembly{
-		Images:     is,
-		Albums:     as,
-		Favorites:  fs,
-		Recent:     recent,
-		HierAlbums: hs,
-		TagAlbums:  ts,
+		Images:      is,
+		Albums:      as,
+		Favorites:   fs,
+		Recent:      recent,
+		HierAlbums:  hs,
+		TagAlbums:   ts,
+		PlaceAlbums: ps,
+		EventAlbums: es,
+		OnThisDay:   onThisDay,
+		YearAlbums:  ys,
+		Tree:        buildAlbumTree(as, hs, labels),
 	}, nil
 }
 
-func filterAndSortAlbums(albums map[string]*Album) []*Album {
+// listedAlbums returns the albums which are not hidden.
+func listedAlbums(as []*Album) []*Album {
+	out := []*Album{}
+	for _, a := range as {
+		if !a.Hidden {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// listedImages returns the images which are not in a hidden album.
+func listedImages(is []*Image, as []*Album) []*Image {
+	hidden := map[*Image]bool{}
+	for _, a := range as {
+		if a.Hidden {
+			for _, i := range a.Images {
+				hidden[i] = true
+			}
+		}
+	}
+
+	out := []*Image{}
+	for _, i := range is {
+		if !hidden[i] {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// assignPages records the page of its directory album that each image is rendered on, and the
+// burst it is stacked in there, so that links to images from other albums and maps work.
+func assignPages(as []*Album) {
+	for _, a := range as {
+		for n, is := range a.pages() {
+			for _, i := range is {
+				if filepath.Dir(i.OutPath) != a.OutPath {
+					continue
+				}
+				i.Page = n + 1
+				for _, f := range a.Frames(i) {
+					f.Page = n + 1
+					f.stackID = stackID(i)
+				}
+			}
+		}
+	}
+}
+
+// updateTimes sets the date range of an album from its images, unless already set, and its
+// modification time to that of its most recently modified image.
+func (a *Album) updateTimes() {
+	var start, end time.Time
+	for _, i := range a.Images {
+		if i.ModTime.After(a.ModTime) {
+			a.ModTime = i.ModTime
+		}
+		if i.Taken.IsZero() {
+			continue
+		}
+		if start.IsZero() || i.Taken.Before(start) {
+			start = i.Taken
+		}
+		if i.Taken.After(end) {
+			end = i.Taken
+		}
+	}
+
+	if a.StartTime.IsZero() {
+		a.StartTime = start
+	}
+	if a.EndTime.IsZero() {
+		a.EndTime = end
+	}
+}
+
+// sortAlbumsByDate sorts albums newest first, while keeping albums which share a top-level
+// and second-level directory together, as the index groups them. Groups are ordered by
+// their newest album.
+func sortAlbumsByDate(as []*Album) {
+	newest := map[string]time.Time{}
+	for _, a := range as {
+		for _, k := range []string{a.Hier[0], strings.Join(a.Hier[0:min(2, len(a.Hier))], "/")} {
+			if a.EndTime.After(newest[k]) {
+				newest[k] = a.EndTime
+			}
+		}
+	}
+
+	sort.SliceStable(as, func(i, j int) bool {
+		for _, n := range []int{1, 2} {
+			ki := strings.Join(as[i].Hier[0:min(n, len(as[i].Hier))], "/")
+			kj := strings.Join(as[j].Hier[0:min(n, len(as[j].Hier))], "/")
+			if ki == kj {
+				continue
+			}
+			if !newest[ki].Equal(newest[kj]) {
+				return newest[ki].After(newest[kj])
+			}
+			return ki > kj
+		}
+		if !as[i].EndTime.Equal(as[j].EndTime) {
+			return as[i].EndTime.After(as[j].EndTime)
+		}
+		return as[i].RelPath > as[j].RelPath
+	})
+}
+
+func filterAndSortAlbums(albums map[string]*Album, minSize int) []*Album {
 	as := []*Album{}
 	for _, a := range albums {
-		imgs := a.Images
-		if len(imgs) < minAlbumSize {
+		if len(a.Images) < minSize {
 			continue
 		}
-		sort.Slice(imgs, func(i, j int) bool {
-			return imgs[i].Taken.Before(imgs[j].Taken)
-		})
-		a.Images = imgs
+		a.finishMeta()
 		for i, p := range a.Images {
 			klog.V(1).Infof("%s: %d = %s [%s] (taken=%s)", a.Title, i, p.InPath, p.Title, p.Taken)
 		}
@@ -241,10 +597,10 @@ func filterAndSortAlbums(albums map[string]*Album) []*Album {
 	return as
 }
 
-func filterAlbumsBySize(albums map[string]*Album) []*Album {
+func filterAlbumsBySize(albums map[string]*Album, minSize int) []*Album {
 	filtered := []*Album{}
 	for _, a := range albums {
-		if len(a.Images) >= minAlbumSize {
+		if len(a.Images) >= minSize {
 			filtered = append(filtered, a)
 		}
 	}
@@ -259,7 +615,7 @@ func albumsToSlice(albums map[string]*Album) []*Album {
 	return slice
 }
 
-func createRecentAlbum(is []*Image, outDir string) *Album {
+func createRecentAlbum(is []*Image, outDir string, size int) *Album {
 	recent := &Album{Title: "Recent", Images: is, OutPath: outDir}
 
 	ri := recent.Images
@@ -267,55 +623,21 @@ func createRecentAlbum(is []*Image, outDir string) *Album {
 		return ri[i].Taken.After(ri[j].Taken)
 	})
 
-	if len(ri) > maxAlbum {
-		ri = ri[0:maxAlbum]
+	if len(ri) > size {
+		ri = ri[0:size]
 	}
 
 	recent.Images = ri
 	return recent
 }
 
-// Validate checks the assembly for potential issues with image and album counts.
+// Validate checks the assembly for potential issues, including any per-image errors
+// encountered during collection. Albums larger than their page size are paginated.
 func (a *Assembly) Validate() []error {
-	var errs []error
+	errs := append([]error{}, a.Errors...)
 
-	// Check album photo count
 	for _, album := range a.Albums {
-		klog.Infof("%s has %d photos [level=%d]", album.Title, len(album.Images), album.HierLevel)
-
-		maxImages := maxAlbum
-		if album.HierLevel == 1 {
-			maxImages = maxTopHierAlbum
-		}
-
-		if album.HierLevel > 1 {
-			maxImages = maxHierAlbum
-		}
-		if len(album.Images) > maxImages {
-			album.Hidden = true
-			errs = append(errs, fmt.Errorf(
-				"album '%s' contains %d images, which exceeds the %d image limit at hierarchy level %d",
-				album.Title, len(album.Images), maxImages, album.HierLevel,
-			))
-		}
-	}
-
-	// Check hierarchical album photo count
-	for _, album := range a.HierAlbums {
-		klog.Infof("Hier %s has %d photos [level=%d]", album.Title, len(album.Images), album.HierLevel)
-
-		maxImages := maxHierAlbum
-		if album.HierLevel == 1 {
-			maxImages = maxTopHierAlbum
-		}
-
-		if len(album.Images) > maxImages {
-			album.Hidden = true
-			errs = append(errs, fmt.Errorf(
-				"hierarchical album '%s' contains %d images, which exceeds the %d image limit at hierarchy level %d",
-				album.Title, len(album.Images), maxImages, album.HierLevel,
-			))
-		}
+		klog.Infof("%s has %d photos across %d pages [level=%d]", album.Title, len(album.Images), len(album.pages()), album.HierLevel)
 	}
 
 	return errs
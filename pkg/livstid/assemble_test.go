@@ -0,0 +1,69 @@
+package livstid
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHiddenAlbums(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "2024", "Private"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "2024", "Private", "album.yaml"), []byte("hidden: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{OutDir: t.TempDir(), MinAlbumSize: 1}
+	albums, hier, favs, tags, places := map[string]*Album{}, map[string]*Album{}, map[string]*Album{}, map[string]*Album{}, map[string]*Album{}
+	is := []*Image{}
+	for n, rel := range []string{"2024/Private/a.jpg", "2024/Public/b.jpg"} {
+		i := &Image{
+			RelPath:  filepath.FromSlash(rel),
+			InPath:   filepath.Join(src, filepath.FromSlash(rel)),
+			Taken:    time.Date(2024, 6, 1+n, 12, 0, 0, 0, time.UTC),
+			Favorite: true,
+			Keywords: []string{"beach"},
+			Place:    &Place{City: "Lisbon", Country: "Portugal"},
+		}
+		is = append(is, i)
+		if err := processImage(i, c, albums, hier, favs, tags, places); err != nil {
+			t.Fatalf("processImage: %v", err)
+		}
+	}
+
+	a, err := buildAssembly(is, albums, hier, favs, tags, places, c)
+	if err != nil {
+		t.Fatalf("buildAssembly: %v", err)
+	}
+
+	// the hidden album is still published, but its photo appears nowhere else
+	if len(a.Albums) != 2 {
+		t.Errorf("found %d albums, want 2", len(a.Albums))
+	}
+	tests := []struct {
+		name string
+		as   []*Album
+	}{
+		{name: "recent", as: []*Album{a.Recent}},
+		{name: "favorites", as: a.Favorites},
+		{name: "tags", as: a.TagAlbums},
+		{name: "places", as: a.PlaceAlbums},
+		{name: "years", as: a.YearAlbums},
+		{name: "hierarchy", as: a.HierAlbums},
+	}
+	for _, tc := range tests {
+		if len(tc.as) == 0 {
+			t.Errorf("%s: no albums", tc.name)
+		}
+		for _, al := range tc.as {
+			for _, i := range al.Images {
+				if i != is[1] {
+					t.Errorf("%s album %q includes %s", tc.name, al.Title, i.RelPath)
+				}
+			}
+		}
+	}
+}
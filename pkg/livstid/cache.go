@@ -0,0 +1,229 @@
+package livstid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// metaCacheVersion must be incremented whenever read() starts extracting new fields,
+// so that stale cache files are discarded rather than silently missing data.
+const metaCacheVersion = 11
+
+// CacheDirName is the cache directory within the output directory used by earlier versions.
+// It is never synced or served, but should be removed from sites deployed by other means.
+var CacheDirName = ".cache"
+
+// metaCacheName is the filename of the metadata cache within the cache directory.
+var metaCacheName = "metadata.json"
+
+// metaEntry is a cached result of read() for a single file.
+type metaEntry struct {
+	ModTime time.Time `json:"mtime"`
+	Image   *Image    `json:"image"`
+	Size    int64     `json:"size"`
+}
+
+// MetaCache is a persistent cache of extracted image metadata, keyed by path.
+// Entries are invalidated whenever the size or modification time of a file changes.
+type MetaCache struct {
+	entries map[string]*metaEntry
+	seen    map[string]bool
+	path    string
+	mu      sync.Mutex
+	dirty   bool
+}
+
+type metaCacheFile struct {
+	Entries map[string]*metaEntry `json:"entries"`
+	Version int                   `json:"version"`
+}
+
+// cacheDir returns the directory used for persistent caches, or "" if caching is disabled. By
+// default, each output directory has a cache of its own within the user's cache directory, so that
+// the exact locations, serial numbers and names it holds are never published.
+func cacheDir(c *Config) string {
+	if c.CacheDir != "" {
+		return c.CacheDir
+	}
+	if c.OutDir == "" {
+		return ""
+	}
+
+	old := filepath.Join(c.OutDir, CacheDirName)
+	if _, err := os.Stat(old); err == nil {
+		klog.Warningf("%s is no longer used and may contain private metadata; remove it", old)
+	}
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		klog.Warningf("metadata will not be cached: %v", err)
+		return ""
+	}
+	out, err := filepath.Abs(c.OutDir)
+	if err != nil {
+		klog.Warningf("metadata will not be cached: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256([]byte(out))
+	return filepath.Join(base, "livstid", hex.EncodeToString(sum[:8]))
+}
+
+// LoadMetaCache loads the metadata cache from dir. An empty dir returns a
+// memory-only cache. If rebuild is set, any existing cache contents are ignored.
+func LoadMetaCache(dir string, rebuild bool) (*MetaCache, error) {
+	mc := &MetaCache{
+		entries: map[string]*metaEntry{},
+		seen:    map[string]bool{},
+	}
+
+	if dir == "" {
+		return mc, nil
+	}
+
+	mc.path = filepath.Join(dir, metaCacheName)
+	if rebuild {
+		klog.Infof("ignoring existing metadata cache at %s", mc.path)
+		mc.dirty = true
+		return mc, nil
+	}
+
+	bs, err := os.ReadFile(mc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return mc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	cf := &metaCacheFile{}
+	if err := json.Unmarshal(bs, cf); err != nil {
+		klog.Warningf("discarding unreadable metadata cache %s: %v", mc.path, err)
+		mc.dirty = true
+		return mc, nil
+	}
+
+	if cf.Version != metaCacheVersion {
+		klog.Infof("discarding metadata cache %s: version %d, want %d", mc.path, cf.Version, metaCacheVersion)
+		mc.dirty = true
+		return mc, nil
+	}
+
+	if cf.Entries != nil {
+		mc.entries = cf.Entries
+	}
+	klog.Infof("loaded %d cached metadata entries from %s", len(mc.entries), mc.path)
+	return mc, nil
+}
+
+// Get returns a copy of the cached image metadata for path, if the cached entry is still valid.
+func (mc *MetaCache) Get(path string, fi os.FileInfo) (*Image, bool) {
+	if mc == nil {
+		return nil, false
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.seen[path] = true
+	e := mc.entries[path]
+	if e == nil || e.Image == nil {
+		return nil, false
+	}
+
+	if e.Size != fi.Size() || !e.ModTime.Equal(fi.ModTime()) {
+		klog.V(1).Infof("cache entry for %s is stale", path)
+		return nil, false
+	}
+
+	i := *e.Image
+	i.Keywords = slices.Clone(i.Keywords)
+	i.People = slices.Clone(i.People)
+	return &i, true
+}
+
+// Put stores a copy of the image metadata for path.
+func (mc *MetaCache) Put(path string, fi os.FileInfo, i *Image) {
+	if mc == nil {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	ci := *i
+	ci.Keywords = slices.Clone(ci.Keywords)
+	ci.People = slices.Clone(ci.People)
+	mc.seen[path] = true
+	mc.entries[path] = &metaEntry{Size: fi.Size(), ModTime: fi.ModTime(), Image: &ci}
+	mc.dirty = true
+}
+
+// PutHashes records the content hashes and sharpness of a cached image, which are kept until the file changes.
+func (mc *MetaCache) PutHashes(path string, i *Image) {
+	if mc == nil {
+		return
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.entries[path]
+	if e == nil || e.Image == nil {
+		return
+	}
+	e.Image.Hash = i.Hash
+	e.Image.DHash = i.DHash
+	e.Image.Sharpness = i.Sharpness
+	e.Image.HasSharpness = i.HasSharpness
+	mc.dirty = true
+}
+
+// Save writes the cache to disk, dropping any entries that were not seen since it was loaded.
+func (mc *MetaCache) Save() error {
+	if mc == nil || mc.path == "" {
+		return nil
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for p := range mc.entries {
+		if !mc.seen[p] {
+			klog.V(1).Infof("pruning cache entry for %s", p)
+			delete(mc.entries, p)
+			mc.dirty = true
+		}
+	}
+
+	if !mc.dirty {
+		return nil
+	}
+
+	bs, err := json.Marshal(&metaCacheFile{Version: metaCacheVersion, Entries: mc.entries})
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(mc.path), 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	tmp := mc.path + ".tmp"
+	if err := os.WriteFile(tmp, bs, 0o644); err != nil { //nolint:gosec // file permissions are standard
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	if err := os.Rename(tmp, mc.path); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+
+	klog.Infof("saved %d metadata entries to %s", len(mc.entries), mc.path)
+	mc.dirty = false
+	return nil
+}
@@ -0,0 +1,96 @@
+package livstid
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// ClockCorrection adjusts the capture times of photos from a camera whose clock was wrong.
+// A correction applies to photos which match every field that is set.
+type ClockCorrection struct {
+	// Dir is an album path such as "2024/Trip", or an absolute source directory. Photos within
+	// nested albums also match.
+	Dir    string `yaml:"dir"`
+	Make   string `yaml:"make"`
+	Model  string `yaml:"model"`
+	Serial string `yaml:"serial"`
+	// Offset is added to capture times, so a camera which was an hour slow needs an offset of 1h.
+	Offset time.Duration `yaml:"offset"`
+}
+
+// Matches returns true if the correction applies to an image.
+func (cc ClockCorrection) Matches(i *Image) bool {
+	if cc.Make != "" && !strings.EqualFold(cc.Make, i.Make) {
+		return false
+	}
+	if cc.Model != "" && !strings.EqualFold(cc.Model, i.Model) {
+		return false
+	}
+	if cc.Serial != "" && cc.Serial != i.Serial {
+		return false
+	}
+	if cc.Dir == "" {
+		return true
+	}
+
+	dir := filepath.Clean(cc.Dir)
+	if filepath.IsAbs(dir) {
+		// InPath is relative if the input directory was given as a relative path
+		in, err := filepath.Abs(i.InPath)
+		if err != nil {
+			klog.Warningf("abs %s: %v", i.InPath, err)
+			return false
+		}
+		return strings.HasPrefix(in, dir+string(filepath.Separator))
+	}
+	return strings.HasPrefix(i.RelPath, dir+string(filepath.Separator))
+}
+
+// validateClockCorrections returns an error for corrections which would apply to every photo, or do nothing.
+func validateClockCorrections(c *Config) error {
+	for n, cc := range c.ClockCorrections {
+		if cc.Dir == "" && cc.Make == "" && cc.Model == "" && cc.Serial == "" {
+			return fmt.Errorf("clock correction %d: one of dir, make, model or serial is required", n+1)
+		}
+		if cc.Offset == 0 {
+			return fmt.Errorf("clock correction %d: offset is required", n+1)
+		}
+	}
+	return nil
+}
+
+// clockCorrection returns the index of the first correction which matches an image, or -1 if none do.
+func clockCorrection(i *Image, ccs []ClockCorrection) int {
+	for n, cc := range ccs {
+		if cc.Matches(i) {
+			return n
+		}
+	}
+	return -1
+}
+
+// applyClockCorrections adjusts capture times by the first correction which matches each image.
+func applyClockCorrections(is []*Image, c *Config) {
+	if len(c.ClockCorrections) == 0 {
+		return
+	}
+
+	counts := make([]int, len(c.ClockCorrections))
+	for _, i := range is {
+		if i.Taken.IsZero() {
+			continue
+		}
+		if n := clockCorrection(i, c.ClockCorrections); n >= 0 {
+			i.Taken = i.Taken.Add(c.ClockCorrections[n].Offset)
+			counts[n]++
+		}
+	}
+
+	for n, cc := range c.ClockCorrections {
+		klog.Infof("clock correction %d (%s): adjusted %d photos", n+1, cc.Offset, counts[n])
+	}
+}
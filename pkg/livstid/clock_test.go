@@ -0,0 +1,84 @@
+package livstid
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClockCorrectionMatches(t *testing.T) {
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Image{
+		InPath:  filepath.Join("testdata", "2024", "Trip", "IMG_1.jpg"),
+		RelPath: filepath.Join("2024", "Trip", "IMG_1.jpg"),
+		Make:    "Canon",
+		Model:   "EOS R6",
+		Serial:  "1234",
+	}
+
+	tests := []struct {
+		name string
+		cc   ClockCorrection
+		want bool
+	}{
+		{name: "make", cc: ClockCorrection{Make: "canon"}, want: true},
+		{name: "other make", cc: ClockCorrection{Make: "Nikon"}, want: false},
+		{name: "make and model", cc: ClockCorrection{Make: "Canon", Model: "eos r6"}, want: true},
+		{name: "other serial", cc: ClockCorrection{Model: "EOS R6", Serial: "5678"}, want: false},
+		{name: "album", cc: ClockCorrection{Dir: "2024/Trip"}, want: true},
+		{name: "parent album", cc: ClockCorrection{Dir: "2024/"}, want: true},
+		{name: "album prefix", cc: ClockCorrection{Dir: "2024/Tr"}, want: false},
+		{name: "absolute dir", cc: ClockCorrection{Dir: filepath.Join(abs, "2024")}, want: true},
+		{name: "other absolute dir", cc: ClockCorrection{Dir: filepath.Join(abs, "2023")}, want: false},
+		{name: "album and other make", cc: ClockCorrection{Dir: "2024", Make: "Nikon"}, want: false},
+	}
+	for _, tc := range tests {
+		if got := tc.cc.Matches(i); got != tc.want {
+			t.Errorf("%s: Matches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestValidateClockCorrections(t *testing.T) {
+	tests := []struct {
+		name    string
+		ccs     []ClockCorrection
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", ccs: []ClockCorrection{{Model: "EOS R6", Offset: time.Hour}}},
+		{name: "every photo", ccs: []ClockCorrection{{Offset: time.Hour}}, wantErr: true},
+		{name: "no offset", ccs: []ClockCorrection{{Dir: "2024"}}, wantErr: true},
+	}
+	for _, tc := range tests {
+		err := validateClockCorrections(&Config{ClockCorrections: tc.ccs})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestApplyClockCorrections(t *testing.T) {
+	tm := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	is := []*Image{
+		{Model: "EOS R6", Taken: tm},
+		{Model: "EOS R6", Serial: "5678", Taken: tm},
+		{Model: "Pixel 8", Taken: tm},
+		{Model: "EOS R6"},
+	}
+	c := &Config{ClockCorrections: []ClockCorrection{
+		{Model: "EOS R6", Serial: "5678", Offset: -time.Minute},
+		{Model: "EOS R6", Offset: time.Hour},
+	}}
+	applyClockCorrections(is, c)
+
+	want := []time.Time{tm.Add(time.Hour), tm.Add(-time.Minute), tm, {}}
+	for n, i := range is {
+		if !i.Taken.Equal(want[n]) {
+			t.Errorf("image %d taken at %s, want %s", n, i.Taken, want[n])
+		}
+	}
+}
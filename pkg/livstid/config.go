@@ -0,0 +1,24 @@
+package livstid
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig overlays settings from a YAML file onto c. Settings missing from the file are left untouched.
+func LoadConfig(path string, c *Config) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	d := yaml.NewDecoder(bytes.NewReader(bs))
+	d.KnownFields(true)
+	if err := d.Decode(c); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package livstid
+
+import (
+	"slices"
+	"strings"
+)
+
+// RatingRejected is the rating of images marked as rejected in Lightroom and darktable.
+const RatingRejected = -1
+
+// DefaultFavoriteRule selects images with the "fav" keyword.
+var DefaultFavoriteRule = FavoriteRule{Keywords: []string{favKeyword}}
+
+// FavoriteRule selects favorite images. An image is a favorite if it matches any of the criteria.
+type FavoriteRule struct {
+	// Keywords are keywords which mark an image as a favorite.
+	Keywords []string `yaml:"keywords"`
+	// Labels are XMP color labels, such as "Red" or "Green", which mark an image as a favorite.
+	Labels []string `yaml:"labels"`
+	// MinRating is the star rating at which images are favorites. If zero, ratings are ignored.
+	MinRating int `yaml:"min_rating"`
+}
+
+// Matches returns true if the rule selects an image.
+func (r FavoriteRule) Matches(i *Image) bool {
+	if r.MinRating > 0 && i.Rating >= r.MinRating {
+		return true
+	}
+	for _, k := range r.Keywords {
+		if slices.Contains(i.Keywords, k) {
+			return true
+		}
+	}
+	for _, l := range r.Labels {
+		if i.Label != "" && strings.EqualFold(i.Label, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejected returns true if an image was marked as rejected.
+func rejected(i *Image) bool {
+	return i.Rating == RatingRejected
+}
+
+// applyCuration marks images as highlights and favorites according to their ratings, labels and keywords.
+func applyCuration(is []*Image, c *Config) {
+	rule := DefaultFavoriteRule
+	if c.Favorites != nil {
+		rule = *c.Favorites
+	}
+
+	for _, i := range is {
+		if rejected(i) {
+			i.Favorite, i.Highlight = false, false
+			continue
+		}
+
+		i.Favorite = i.Favorite || rule.Matches(i)
+		if c.HighlightRating > 0 && i.Rating >= c.HighlightRating {
+			i.Highlight = true
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package livstid
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anthonynsimon/bild/transform"
+	"k8s.io/klog/v2"
+)
+
+// Dedupe modes, which control how duplicate photos are detected.
+const (
+	// DedupeExact treats files with identical content as duplicates.
+	DedupeExact = "exact"
+	// DedupePerceptual also treats visually similar photos as duplicates, such as resized copies
+	// and re-exports.
+	DedupePerceptual = "perceptual"
+	// DedupeOff publishes every file found.
+	DedupeOff = "off"
+)
+
+var (
+	defaultDedupeThreshold = 4
+	// editSuffix matches what edited copies add to the name of the original, as in IMG_1234-edited,
+	// IMG_1234 copy or IMG_1234 (1).
+	editSuffix = regexp.MustCompile(`(?i)^(\s?\(\d+\)|[-_ ](edited|edit|copy)([-_ (].*)?)$`)
+)
+
+// DupeCluster is a set of duplicate photos, and the copy chosen to be published.
+type DupeCluster struct {
+	Best   *Image
+	Others []*Image
+	// Distance is the largest perceptual distance between matched photos, or 0 if they are identical.
+	Distance int
+}
+
+// FindDupes searches the input directories for duplicate photos without building the site.
+func FindDupes(c *Config) ([]*DupeCluster, error) {
+	mode := c.Dedupe
+	if mode == DedupeOff {
+		mode = DedupeExact
+	}
+
+	is, mc, err := loadImages(c)
+	if err != nil {
+		return nil, err
+	}
+
+	_, clusters, err := dedupe(is, mode, c, mc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mc.Save(); err != nil {
+		klog.Errorf("unable to save metadata cache: %v", err)
+	}
+	return clusters, nil
+}
+
+// dedupe returns the images with duplicates removed according to mode, along with the clusters found.
+func dedupe(is []*Image, mode string, c *Config, mc *MetaCache) ([]*Image, []*DupeCluster, error) {
+	switch mode {
+	case "", DedupeExact, DedupePerceptual:
+	case DedupeOff:
+		return is, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown dedupe mode %q (want %s, %s or %s)", mode, DedupeExact, DedupePerceptual, DedupeOff)
+	}
+
+	perceptual := mode == DedupePerceptual
+	// only files of the same size can be identical, so others, such as most videos, aren't read
+	hashImages(sameSize(is), true, false, c.Workers, mc)
+	if perceptual {
+		hashImages(is, false, true, c.Workers, mc)
+	}
+
+	// union-find over images, so that chains of similar photos form a single cluster
+	parent := make([]int, len(is))
+	for n := range parent {
+		parent[n] = n
+	}
+	var root func(n int) int
+	root = func(n int) int {
+		for parent[n] != n {
+			parent[n] = parent[parent[n]]
+			n = parent[n]
+		}
+		return n
+	}
+	union := func(a, b int) {
+		parent[root(a)] = root(b)
+	}
+
+	byHash := map[string]int{}
+	byCapture := map[string][]int{}
+	for n, i := range is {
+		if i.Hash != "" {
+			if m, ok := byHash[i.Hash]; ok {
+				union(n, m)
+				continue
+			}
+			byHash[i.Hash] = n
+		}
+
+		// edited copies have different content, but the capture settings of their original
+		if i.Taken.IsZero() {
+			continue
+		}
+		key := fmt.Sprintf("%s-%s-%d", i.Taken, i.Speed, i.ISO)
+		for _, m := range byCapture[key] {
+			if editedCopy(i, is[m]) {
+				union(n, m)
+			}
+		}
+		byCapture[key] = append(byCapture[key], n)
+	}
+
+	dists := map[[2]int]int{}
+	if perceptual {
+		threshold := orDefault(c.DedupeThreshold, defaultDedupeThreshold)
+		t := &bkTree{}
+		for b, i := range is {
+			if i.DHash == "" {
+				continue
+			}
+			h := parseDHash(i.DHash)
+			t.Search(h, threshold, func(a, d int) {
+				if mayBeCopies(is[a], i) {
+					dists[[2]int{a, b}] = d
+					union(a, b)
+				}
+			})
+			t.Add(h, b)
+		}
+	}
+
+	groups := map[int][]int{}
+	for n := range is {
+		r := root(n)
+		groups[r] = append(groups[r], n)
+	}
+
+	kept := []*Image{}
+	clusters := []*DupeCluster{}
+	for n, i := range is {
+		g := groups[root(n)]
+		if len(g) == 1 {
+			kept = append(kept, i)
+			continue
+		}
+		// each cluster is handled once, by its first member
+		if g[0] != n {
+			continue
+		}
+
+		members := []*Image{}
+		for _, m := range g {
+			members = append(members, is[m])
+		}
+		sort.Slice(members, func(a, b int) bool {
+			return betterCopy(members[a], members[b])
+		})
+
+		dc := &DupeCluster{Best: members[0], Others: members[1:]}
+		for _, a := range g {
+			for _, b := range g {
+				if d, ok := dists[[2]int{a, b}]; ok && d > dc.Distance {
+					dc.Distance = d
+				}
+			}
+		}
+		for _, o := range dc.Others {
+			klog.Infof("photo dupe found: %s (using %s)", o.InPath, dc.Best.InPath)
+		}
+
+		clusters = append(clusters, dc)
+		kept = append(kept, dc.Best)
+	}
+
+	sort.Slice(clusters, func(a, b int) bool {
+		return clusters[a].Best.InPath < clusters[b].Best.InPath
+	})
+	return kept, clusters, nil
+}
+
+// mayBeCopies returns false if two visually similar photos are more likely to be separate shots,
+// such as burst frames: dated photos from the same camera at the same resolution, unless one is
+// named after the other.
+func mayBeCopies(a, b *Image) bool {
+	if a.Model == "" || b.Model == "" || a.Taken.IsZero() || b.Taken.IsZero() {
+		return true
+	}
+	if a.Make != b.Make || a.Model != b.Model || a.Width != b.Width || a.Height != b.Height {
+		return true
+	}
+	return editedCopy(a, b)
+}
+
+// editedCopy returns true if one image is named as an edited copy of the other, as edited copies
+// are often saved alongside the original as IMG_1234-edited.jpg. Names which merely share a
+// prefix, such as IMG_1 and IMG_12, are separate shots, while those with the same name are copies
+// in different formats.
+func editedCopy(a, b *Image) bool {
+	sa := strings.TrimSuffix(filepath.Base(a.InPath), filepath.Ext(a.InPath))
+	sb := strings.TrimSuffix(filepath.Base(b.InPath), filepath.Ext(b.InPath))
+	if len(sa) > len(sb) {
+		sa, sb = sb, sa
+	}
+	rest, ok := strings.CutPrefix(sb, sa)
+	return ok && (rest == "" || editSuffix.MatchString(rest))
+}
+
+// bkTree indexes perceptual hashes by Hamming distance, so that similar hashes can be found without
+// comparing every pair.
+type bkTree struct {
+	root *bkNode
+}
+
+type bkNode struct {
+	hash     uint64
+	idx      []int
+	children map[int]*bkNode
+}
+
+// Add records that image n has hash h.
+func (t *bkTree) Add(h uint64, n int) {
+	if t.root == nil {
+		t.root = &bkNode{hash: h, idx: []int{n}}
+		return
+	}
+	node := t.root
+	for {
+		d := bits.OnesCount64(node.hash ^ h)
+		if d == 0 {
+			node.idx = append(node.idx, n)
+			return
+		}
+		next := node.children[d]
+		if next == nil {
+			if node.children == nil {
+				node.children = map[int]*bkNode{}
+			}
+			node.children[d] = &bkNode{hash: h, idx: []int{n}}
+			return
+		}
+		node = next
+	}
+}
+
+// Search calls fn with each image whose hash is within limit bits of h, and its distance.
+func (t *bkTree) Search(h uint64, limit int, fn func(n, d int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := bits.OnesCount64(node.hash ^ h)
+		if d <= limit {
+			for _, n := range node.idx {
+				fn(n, d)
+			}
+		}
+		// by the triangle inequality, matches can only be below children within limit of d
+		for cd, child := range node.children {
+			if cd >= d-limit && cd <= d+limit {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
+
+// betterCopy returns true if a should be published in preference to b: the higher resolution,
+// then the one with more metadata, then edited copies (which have longer names), then by path.
+func betterCopy(a, b *Image) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	if sa, sb := metadataScore(a), metadataScore(b); sa != sb {
+		return sa > sb
+	}
+	if len(a.BasePath) != len(b.BasePath) {
+		return len(a.BasePath) > len(b.BasePath)
+	}
+	return a.InPath < b.InPath
+}
+
+// metadataScore returns a rough measure of how much metadata an image carries.
+func metadataScore(i *Image) int {
+	score := len(i.Keywords) + len(i.Title) + len(i.Description) + len(i.Label) + i.Rating
+	if !i.Taken.IsZero() {
+		score += 100
+	}
+	if i.GPS != nil {
+		score += 100
+	}
+	return score
+}
+
+// sameSize returns the images whose files are the same size as that of another image.
+func sameSize(is []*Image) []*Image {
+	sizes := make([]int64, len(is))
+	count := map[int64]int{}
+	for n, i := range is {
+		fi, err := os.Stat(i.InPath)
+		if err != nil {
+			klog.Errorf("stat: %v", err)
+			sizes[n] = -1
+			continue
+		}
+		sizes[n] = fi.Size()
+		count[sizes[n]]++
+	}
+
+	out := []*Image{}
+	for n, i := range is {
+		if sizes[n] >= 0 && count[sizes[n]] > 1 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// hashImages computes missing content hashes, and perceptual hashes and sharpness, as requested,
+// recording them in mc.
+func hashImages(is []*Image, content, perceptual bool, workers int, mc *MetaCache) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	idx := make(chan int)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range idx {
+				i := is[n]
+				changed := false
+				if content && i.Hash == "" {
+					h, err := contentHash(i.InPath)
+					if err != nil {
+						klog.Errorf("content hash: %v", err)
+						continue
+					}
+					i.Hash = h
+					changed = true
+				}
+
+				if perceptual && (i.DHash == "" || !i.HasSharpness) && !i.Video {
+					img, err := decodeSource(i)
+					if err != nil {
+						klog.Errorf("perceptual hash for %s: %v", i.InPath, err)
+					} else {
+						i.DHash = strconv.FormatUint(dHash(img), 16)
+						i.Sharpness = sharpness(img)
+						i.HasSharpness = true
+						changed = true
+					}
+				}
+
+				if changed {
+					mc.PutHashes(i.InPath, i)
+				}
+			}
+		}()
+	}
+
+	for n := range is {
+		idx <- n
+	}
+	close(idx)
+	wg.Wait()
+}
+
+// contentHash returns the SHA-256 of a file.
+func contentHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			klog.Errorf("Failed to close file: %v", err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("read: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dHash returns the difference hash of an image: whether each pixel of a 9x8 grayscale
+// reduction is brighter than its right-hand neighbor.
+func dHash(img image.Image) uint64 {
+	small := transform.Resize(img, 9, 8, transform.Box)
+
+	var h uint64
+	for y := range 8 {
+		for x := range 8 {
+			if luma(small, x, y) > luma(small, x+1, y) {
+				h |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return h
+}
+
+// luma returns the brightness of a pixel.
+func luma(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// parseDHash parses a hexadecimal perceptual hash.
+func parseDHash(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		klog.Warningf("invalid perceptual hash %q: %v", s, err)
+	}
+	return h
+}
@@ -0,0 +1,146 @@
+package livstid
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBKTreeSearch(t *testing.T) {
+	hashes := []uint64{0b0000, 0b0001, 0b0011, 0b0111, 0b1111, 0b0000, 0xFF00}
+	tree := &bkTree{}
+	for n, h := range hashes {
+		tree.Add(h, n)
+	}
+
+	tests := []struct {
+		h     uint64
+		limit int
+		want  []int
+	}{
+		{h: 0b0000, limit: 0, want: []int{0, 5}},
+		{h: 0b0000, limit: 1, want: []int{0, 1, 5}},
+		{h: 0b0000, limit: 2, want: []int{0, 1, 2, 5}},
+		{h: 0b1111, limit: 1, want: []int{3, 4}},
+		{h: 0xFF00, limit: 0, want: []int{6}},
+		{h: 0xF0F0, limit: 3, want: []int{}},
+	}
+	for _, tc := range tests {
+		got := []int{}
+		tree.Search(tc.h, tc.limit, func(n, _ int) {
+			got = append(got, n)
+		})
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("Search(%b, %d) = %v, want %v", tc.h, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestEditedCopy(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "IMG_1234.jpg", b: "IMG_1234-edited.jpg", want: true},
+		{a: "IMG_1234-EDITED.jpg", b: "IMG_1234.jpg", want: true},
+		{a: "IMG_1234.jpg", b: "IMG_1234 (1).jpg", want: true},
+		{a: "IMG_1234.jpg", b: "IMG_1234(2).jpg", want: true},
+		{a: "IMG_1234.jpg", b: "IMG_1234 copy.jpg", want: true},
+		{a: "IMG_1234.jpg", b: "IMG_1234 copy 2.jpg", want: true},
+		{a: "IMG_1234.jpg", b: "IMG_1234.heic", want: true},
+		{a: "IMG_1.jpg", b: "IMG_12.jpg", want: false},
+		{a: "IMG_1234.jpg", b: "IMG_1234_2.jpg", want: false},
+		{a: "IMG_1234.jpg", b: "IMG_1234-editing.jpg", want: false},
+		{a: "IMG_1234.jpg", b: "IMG_5678.jpg", want: false},
+	}
+	for _, tc := range tests {
+		if got := editedCopy(&Image{InPath: tc.a}, &Image{InPath: tc.b}); got != tc.want {
+			t.Errorf("editedCopy(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestMayBeCopies(t *testing.T) {
+	tm := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	shot := func(name string, w int64) *Image {
+		return &Image{InPath: name, Make: "Apple", Model: "iPhone", Taken: tm, Width: w, Height: 100}
+	}
+
+	tests := []struct {
+		name string
+		a, b *Image
+		want bool
+	}{
+		{name: "burst frames", a: shot("IMG_1.jpg", 100), b: shot("IMG_2.jpg", 100), want: false},
+		{name: "edited copy", a: shot("IMG_1.jpg", 100), b: shot("IMG_1-edited.jpg", 100), want: true},
+		{name: "resized copy", a: shot("IMG_1.jpg", 100), b: shot("IMG_2.jpg", 50), want: true},
+		{name: "no camera", a: &Image{InPath: "a.jpg"}, b: shot("IMG_2.jpg", 100), want: true},
+	}
+	for _, tc := range tests {
+		if got := mayBeCopies(tc.a, tc.b); got != tc.want {
+			t.Errorf("%s: mayBeCopies = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	dir := t.TempDir()
+	tm := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	image := func(name, content string, width int64) *Image {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return &Image{InPath: path, Taken: tm, Speed: "1/100", ISO: 100, Width: width, Height: 100}
+	}
+
+	is := []*Image{
+		image("a.jpg", "same", 100),
+		image("b.jpg", "same", 100),
+		image("IMG_1.jpg", "original", 100),
+		image("IMG_1-edited.jpg", "edited", 200),
+		image("IMG_12.jpg", "another frame", 100),
+		image("clip.mp4", "unique video", 0),
+	}
+
+	tests := []struct {
+		mode     string
+		kept     []string
+		clusters int
+		wantErr  bool
+	}{
+		{mode: DedupeOff, kept: []string{"a.jpg", "b.jpg", "IMG_1.jpg", "IMG_1-edited.jpg", "IMG_12.jpg", "clip.mp4"}},
+		{mode: DedupeExact, kept: []string{"a.jpg", "IMG_1-edited.jpg", "IMG_12.jpg", "clip.mp4"}, clusters: 2},
+		{mode: "fuzzy", wantErr: true},
+	}
+	for _, tc := range tests {
+		kept, clusters, err := dedupe(is, tc.mode, &Config{Workers: 2}, nil)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("dedupe(%s) error = %v, want error %v", tc.mode, err, tc.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+
+		got := []string{}
+		for _, i := range kept {
+			got = append(got, filepath.Base(i.InPath))
+		}
+		if !slices.Equal(got, tc.kept) {
+			t.Errorf("dedupe(%s) kept %v, want %v", tc.mode, got, tc.kept)
+		}
+		if len(clusters) != tc.clusters {
+			t.Errorf("dedupe(%s) found %d clusters, want %d", tc.mode, len(clusters), tc.clusters)
+		}
+	}
+
+	// files of a size no other file has are never read
+	for _, i := range is {
+		if name := filepath.Base(i.InPath); i.Hash != "" && name != "a.jpg" && name != "b.jpg" {
+			t.Errorf("%s was hashed", name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package livstid
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// EventDateFormat is the date format used in event titles.
+	EventDateFormat = "2006-01-02"
+
+	defaultEventDistance = 100000.0
+)
+
+// detectEvents splits directory albums into virtual event albums, starting a new event whenever
+// consecutive photos are further apart than c.EventGap in time, or c.EventDistance in space.
+// Albums which form a single event are left alone.
+func detectEvents(as []*Album, c *Config, minSize int) []*Album {
+	if c.EventGap <= 0 {
+		return nil
+	}
+
+	maxDist := c.EventDistance
+	if maxDist == 0 {
+		maxDist = defaultEventDistance
+	}
+
+	events := []*Album{}
+	for _, a := range as {
+		es := clusterEvents(a.Images, c.EventGap, maxDist)
+		if len(es) < 2 {
+			continue
+		}
+
+		seen := map[string]int{}
+		for _, is := range es {
+			if len(is) < minSize {
+				continue
+			}
+
+			title := eventTitle(is)
+			// events starting on the same day are numbered to keep their paths distinct
+			name := is[0].Taken.Format(EventDateFormat)
+			seen[name]++
+			if seen[name] > 1 {
+				name = fmt.Sprintf("%s-%d", name, seen[name])
+			}
+
+			events = append(events, &Album{
+				InPath:    a.InPath,
+				RelPath:   filepath.Join("events", a.RelPath, name),
+				OutPath:   filepath.Join(c.OutDir, "events", urlSafePath(a.RelPath), name),
+				Title:     title,
+				Hier:      slices.Concat([]string{"events"}, a.Hier, []string{title}),
+				Images:    is,
+				StartTime: is[0].Taken,
+				EndTime:   is[len(is)-1].Taken,
+			})
+		}
+		klog.V(1).Infof("%s: found %d events", a.Title, len(es))
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].RelPath > events[j].RelPath
+	})
+	return events
+}
+
+// clusterEvents groups images by gaps in time and distance. Images without a date are skipped.
+func clusterEvents(is []*Image, gap time.Duration, maxDist float64) [][]*Image {
+	dated := []*Image{}
+	for _, i := range is {
+		if !i.Taken.IsZero() {
+			dated = append(dated, i)
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].Taken.Before(dated[j].Taken)
+	})
+
+	events := [][]*Image{}
+	var cur []*Image
+	var lastGPS *Coordinates
+
+	for _, i := range dated {
+		if len(cur) > 0 {
+			prev := cur[len(cur)-1]
+			split := i.Taken.Sub(prev.Taken) > gap
+			// compare against the last known location, as not every photo is geotagged
+			if i.GPS != nil && lastGPS != nil && maxDist > 0 && i.GPS.Distance(lastGPS) > maxDist {
+				split = true
+			}
+			if split {
+				events = append(events, cur)
+				cur = nil
+				lastGPS = nil
+			}
+		}
+
+		cur = append(cur, i)
+		if i.GPS != nil {
+			lastGPS = i.GPS
+		}
+	}
+
+	if len(cur) > 0 {
+		events = append(events, cur)
+	}
+	return events
+}
+
+// eventTitle returns a title describing the date range of an event.
+func eventTitle(is []*Image) string {
+	start := is[0].Taken.Format(EventDateFormat)
+	end := is[len(is)-1].Taken.Format(EventDateFormat)
+	if start == end {
+		return start
+	}
+	return start + " – " + end
+}
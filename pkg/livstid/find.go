@@ -1,7 +1,6 @@
 package livstid
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -45,6 +44,7 @@ func read(path string, et *exiftool.Exiftool) (*Image, error) {
 
 	i.LensMake, _ = fi.GetString("LensMake")
 	i.LensModel, _ = fi.GetString("LensModel")
+	i.Serial, _ = fi.GetString("SerialNumber")
 
 	i.Height, err = fi.GetInt("ImageHeight")
 	if err != nil {
@@ -56,6 +56,21 @@ func read(path string, et *exiftool.Exiftool) (*Image, error) {
 		return i, fmt.Errorf("get ImageWidth: %w", err)
 	}
 
+	i.Orientation = 1
+	if o, err := fi.GetString("Orientation"); err == nil {
+		i.Orientation = parseOrientation(o)
+	}
+
+	// formats which are decoded upright already report the dimensions they are displayed at
+	f := formatFor(path)
+	if swapsAxes(i.Orientation) && (f == nil || !f.Oriented) {
+		i.Width, i.Height = i.Height, i.Width
+	}
+
+	if f != nil && f.Video {
+		readVideo(i, fi)
+	}
+
 	i.ISO, err = fi.GetInt("ISO")
 	if err != nil {
 		klog.V(1).Infof("unable to get ISO for %s: %v", path, err)
@@ -77,7 +92,13 @@ func read(path string, et *exiftool.Exiftool) (*Image, error) {
 	}
 
 	i.FocalLength = strings.ReplaceAll(i.FocalLength, ".0", "")
+	i.GPS = readGPS(fi)
 	i.Keywords, _ = fi.GetStrings("Keywords")
+	if r, err := fi.GetInt("Rating"); err == nil {
+		i.Rating = int(r)
+	}
+	i.Label, _ = fi.GetString("Label")
+	i.People, _ = fi.GetStrings("RegionName")
 	i.Description, _ = fi.GetString("ImageDescription")
 
 	i.Title, err = fi.GetString("Headline")
@@ -86,6 +107,9 @@ func read(path string, et *exiftool.Exiftool) (*Image, error) {
 	}
 
 	ds, err := fi.GetString("DateTimeOriginal")
+	if err != nil && i.Video {
+		return i, readVideoTaken(path, i, fi)
+	}
 	if err != nil {
 		klog.V(1).Infof("unable to get date time for %s: %v", path, err)
 		return i, nil
@@ -95,77 +119,173 @@ func read(path string, et *exiftool.Exiftool) (*Image, error) {
 	if err != nil {
 		return i, fmt.Errorf("parse time %q: %w", ds, err)
 	}
+	readZone(i, fi)
 
 	return i, nil
 }
 
-func removeDupes(is []*Image) []*Image {
-	seen := map[string]*Image{}
-	for _, i := range is {
-		key := fmt.Sprintf("%s-%s-%d", i.Taken, i.Speed, i.ISO)
-		if seen[key] == nil {
-			seen[key] = i
-			continue
+// readVideo extracts video-specific metadata.
+func readVideo(i *Image, fi exiftool.FileMetadata) {
+	i.Video = true
+
+	if ds, err := fi.GetString("Duration"); err == nil {
+		d, err := parseExifDuration(ds)
+		if err != nil {
+			klog.Warningf("unable to parse duration %q: %v", ds, err)
 		}
-		klog.Infof("photo dupe found: %s (choosing best)", i.InPath)
+		i.Duration = d
+	}
 
-		if len(i.Description) > len(seen[key].Description) {
-			klog.V(1).Infof("will use %s instead!", i.BasePath)
-			seen[key] = i
-			continue
+	// videos record rotation in degrees rather than as an EXIF orientation
+	if r, err := fi.GetInt("Rotation"); err == nil && (r == 90 || r == 270) {
+		i.Width, i.Height = i.Height, i.Width
+	}
+}
+
+// readVideoTaken extracts the creation time of a video, preferring the zoned Apple CreationDate
+// over the QuickTime CreateDate, which is stored in UTC.
+func readVideoTaken(path string, i *Image, fi exiftool.FileMetadata) error {
+	if ds, err := fi.GetString("CreationDate"); err == nil {
+		t, err := time.Parse(exifDate+"-07:00", ds)
+		if err == nil {
+			i.Taken = t
+			i.Zoned = true
+			return nil
 		}
+		klog.V(1).Infof("unable to parse CreationDate %q: %v", ds, err)
+	}
 
-		// use the longest base path? so that we include '-edited' photos.
-		if len(i.BasePath) > len(seen[key].BasePath) {
-			klog.V(1).Infof("will use %s instead!", i.BasePath)
-			seen[key] = i
+	for _, k := range []string{"CreateDate", "MediaCreateDate"} {
+		ds, err := fi.GetString(k)
+		if err != nil || strings.HasPrefix(ds, "0000") {
+			continue
+		}
+		t, err := time.Parse(exifDate, ds)
+		if err != nil {
+			return fmt.Errorf("parse %s %q: %w", k, ds, err)
 		}
+		i.Taken = t
+		i.UTC = true
+		return nil
 	}
 
-	result := []*Image{}
-	for _, k := range seen {
-		result = append(result, k)
+	klog.V(1).Infof("unable to get creation time for video %s", path)
+	return nil
+}
+
+// lazyExiftool starts exiftool on first use, so that fully cached trees never spawn it.
+type lazyExiftool struct {
+	et *exiftool.Exiftool
+}
+
+// Get returns a running exiftool instance, starting one if necessary.
+func (l *lazyExiftool) Get() (*exiftool.Exiftool, error) {
+	if l.et != nil {
+		return l.et, nil
+	}
+	// print signed decimal degrees so that coordinates can be parsed as floats
+	et, err := exiftool.NewExiftool(exiftool.CoordFormant("%+.6f"))
+	if err != nil {
+		return nil, fmt.Errorf("exiftool: %w", err)
 	}
-	return result
+	l.et = et
+	return et, nil
 }
 
-// Find searches for images in a directory tree.
-func Find(root string, sidecars bool) ([]*Image, error) {
+// Close stops exiftool if it was started.
+func (l *lazyExiftool) Close() {
+	if l.et == nil {
+		return
+	}
+	if err := l.et.Close(); err != nil {
+		klog.Errorf("Failed to close exiftool: %v", err)
+	}
+	l.et = nil
+}
+
+// Find searches for images in a root directory tree, consulting mc (if non-nil) before extracting metadata.
+func Find(r Root, sidecars bool, mc *MetaCache) ([]*Image, error) {
+	root := r.Path
 	klog.Infof("finding files in %s ...", root)
 	found := []*Image{}
 
-	et, err := exiftool.NewExiftool()
-	if err != nil {
-		klog.Exitf("exiftool failed: %v\n", err)
-	}
-	defer func() {
-		if err := et.Close(); err != nil {
-			klog.Errorf("Failed to close exiftool: %v", err)
-		}
-	}()
+	et := &lazyExiftool{}
+	defer et.Close()
 
-	err = godirwalk.Walk(root, &godirwalk.Options{
-		Callback: func(path string, _ *godirwalk.Dirent) error {
+	paths := []string{}
+	raws := map[string]string{}
+	// JSON files by directory, which may be Takeout sidecars
+	jsons := map[string][]string{}
+
+	err := godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(path string, de *godirwalk.Dirent) error {
 			if filepath.Base(path)[0] == '.' {
 				return godirwalk.SkipThis
 			}
 
-			if strings.HasSuffix(path, "jpg") {
-				img, err := processJPG(path, root, et, sidecars)
-				if err != nil {
-					return err
-				}
-				found = append(found, img)
+			if !de.IsDir() && strings.EqualFold(filepath.Ext(path), ".json") {
+				jsons[filepath.Dir(path)] = append(jsons[filepath.Dir(path)], filepath.Base(path))
+				return nil
+			}
+
+			if de.IsDir() || formatFor(path) == nil {
+				return nil
 			}
 
+			if isRaw(path) {
+				raws[pairKey(path)] = path
+			}
+			paths = append(paths, path)
 			return nil
 		},
 	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	// pair RAW files with a still image of the same name, so that RAW+JPEG shots appear once
+	paired := map[string]string{}
+	for _, p := range paths {
+		f := formatFor(p)
+		if f.Raw || f.Video {
+			continue
+		}
+		if rp, ok := raws[pairKey(p)]; ok {
+			paired[rp] = p
+		}
+	}
+
+	for _, p := range paths {
+		if _, ok := paired[p]; ok {
+			klog.V(1).Infof("%s is paired with %s", p, paired[p])
+			continue
+		}
+
+		img, err := processFile(p, r, et, mc)
+		if err != nil {
+			return nil, err
+		}
+
+		if rp, ok := raws[pairKey(p)]; ok && paired[rp] == p {
+			img.RawPath = rp
+		}
+
+		// XMP sidecars take precedence over embedded metadata, and Takeout sidecars fill any gaps
+		if err := applyXMPSidecars(img, et, mc); err != nil {
+			klog.Errorf("XMP sidecars: %v", err)
+		}
+		if sidecars {
+			if err := processSidecars(img, jsons[filepath.Dir(p)]); err != nil {
+				klog.Errorf("sidecars: %v", err)
+			}
+		}
+		found = append(found, img)
+	}
 
-	return removeDupes(found), err
+	return found, nil
 }
 
-func processJPG(path, root string, et *exiftool.Exiftool, sidecars bool) (*Image, error) {
+func processFile(path string, r Root, et *lazyExiftool, mc *MetaCache) (*Image, error) {
 	klog.V(1).Infof("found %s", path)
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -173,49 +293,40 @@ func processJPG(path, root string, et *exiftool.Exiftool, sidecars bool) (*Image
 		return nil, fmt.Errorf("stat: %w", err)
 	}
 
-	i, err := read(path, et)
-	if err != nil {
-		klog.Errorf("read failure: %v", err)
-		return nil, err
+	i, ok := mc.Get(path, fi)
+	if ok {
+		klog.V(1).Infof("using cached metadata for %s", path)
+	} else {
+		e, err := et.Get()
+		if err != nil {
+			return nil, err
+		}
+		i, err = read(path, e)
+		if err != nil {
+			klog.Errorf("read failure: %v", err)
+			return nil, err
+		}
+		mc.Put(path, fi, i)
 	}
 
 	i.InPath = path
-	i.RelPath, err = filepath.Rel(root, path)
+	i.RelPath, err = filepath.Rel(r.Path, path)
 	if err != nil {
 		return nil, fmt.Errorf("rel path: %w", err)
 	}
+	switch {
+	case r.Prefix != "":
+		i.RelPath = filepath.Join(r.Prefix, i.RelPath)
+	case r.topAlbum && filepath.Dir(i.RelPath) == ".":
+		// photos at the top of an input directory are grouped into an album named after it
+		abs, err := filepath.Abs(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("abs: %w", err)
+		}
+		i.RelPath = filepath.Join(filepath.Base(abs), i.RelPath)
+	}
 	i.BasePath = urlSafePath(filepath.Base(path))
 	i.Hier = strings.Split(i.RelPath, string(filepath.Separator))
 	i.ModTime = fi.ModTime()
-
-	if sidecars {
-		if err := processSidecars(i); err != nil {
-			klog.Errorf("sidecars: %v", err)
-		}
-	}
-
 	return i, nil
 }
-
-func processSidecars(i *Image) error {
-	// so far we only process Google Takeout sidecars
-	tp := i.InPath + ".json"
-	if _, err := os.Stat(tp); err != nil {
-		//nolint:nilerr // no sidecar file is not an error
-		return nil
-	}
-	bs, err := os.ReadFile(tp)
-	if err != nil {
-		return fmt.Errorf("read: %w", err)
-	}
-
-	side := &TakeoutSidecar{}
-	if err := json.Unmarshal(bs, side); err != nil {
-		return fmt.Errorf("unmarshal: %w", err)
-	}
-	if side.Description != "" {
-		i.Title = side.Description
-		klog.Infof("%s: found sidecar title: %q", i.BasePath, i.Title)
-	}
-	return nil
-}
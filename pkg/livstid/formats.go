@@ -0,0 +1,180 @@
+package livstid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthonynsimon/bild/imgio"
+	"k8s.io/klog/v2"
+
+	// Register additional decoders for image.Decode.
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// SourceFormat describes a type of source file that livstid knows how to publish.
+type SourceFormat struct {
+	// Decode decodes the file into pixels for thumbnailing.
+	Decode func(path string) (image.Image, error)
+	// Name is a short human-readable name, such as "jpeg".
+	Name string
+	// Extensions are the lowercase file extensions for this format, including the dot.
+	Extensions []string
+	// WebSafe formats are published as-is; everything else is published as a JPEG derivative.
+	WebSafe bool
+	// Oriented is set if Decode already applies the EXIF orientation.
+	Oriented bool
+	// Video formats are decoded into a poster frame, and may be transcoded for publishing.
+	Video bool
+	// Raw formats are paired with a JPEG sibling when one exists, otherwise their embedded preview is used.
+	Raw bool
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]*SourceFormat{}
+
+	// ConvertTimeout is the maximum amount of time to wait for an external converter.
+	ConvertTimeout = 2 * time.Minute
+
+	// Converters are external commands used to convert formats Go can't decode natively into JPEG.
+	// The first one found in $PATH is used. %in and %out are replaced with the input and output paths.
+	Converters = [][]string{
+		{"magick", "%in", "%out"},
+		{"heif-convert", "%in", "%out"},
+		{"sips", "-s", "format", "jpeg", "%in", "--out", "%out"},
+		{"convert", "%in", "%out"},
+	}
+
+	// derivativeQuality is the JPEG quality used for full-size derivatives of non web-safe formats.
+	derivativeQuality = 92
+)
+
+func init() {
+	RegisterFormat(&SourceFormat{Name: "jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe"}, Decode: imgio.Open, WebSafe: true})
+	RegisterFormat(&SourceFormat{Name: "png", Extensions: []string{".png"}, Decode: imgio.Open, WebSafe: true})
+	RegisterFormat(&SourceFormat{Name: "webp", Extensions: []string{".webp"}, Decode: imgio.Open, WebSafe: true})
+	RegisterFormat(&SourceFormat{Name: "tiff", Extensions: []string{".tif", ".tiff"}, Decode: imgio.Open})
+	RegisterFormat(&SourceFormat{Name: "heic", Extensions: []string{".heic", ".heif"}, Decode: decodeExternal, Oriented: true})
+	RegisterFormat(&SourceFormat{Name: "avif", Extensions: []string{".avif"}, Decode: decodeExternal, Oriented: true})
+	RegisterFormat(&SourceFormat{
+		Name:       "raw",
+		Extensions: []string{".cr2", ".cr3", ".crw", ".nef", ".nrw", ".arw", ".srf", ".sr2", ".dng", ".raf", ".orf", ".rw2", ".pef", ".srw", ".3fr", ".iiq"},
+		Decode:     decodeRawPreview,
+		Raw:        true,
+	})
+	RegisterFormat(&SourceFormat{Name: "mp4", Extensions: []string{".mp4", ".m4v"}, Decode: decodePoster, WebSafe: true, Oriented: true, Video: true})
+	RegisterFormat(&SourceFormat{Name: "quicktime", Extensions: []string{".mov"}, Decode: decodePoster, WebSafe: true, Oriented: true, Video: true})
+}
+
+// RegisterFormat adds a source format, replacing any format previously registered for the same extensions.
+func RegisterFormat(f *SourceFormat) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for _, ext := range f.Extensions {
+		formats[strings.ToLower(ext)] = f
+	}
+}
+
+// formatFor returns the source format for a path, or nil if it is not a supported format.
+func formatFor(path string) *SourceFormat {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// isJPEG returns true if the path has a JPEG extension.
+func isJPEG(path string) bool {
+	f := formatFor(path)
+	return f != nil && f.Name == "jpeg"
+}
+
+// decodeSource decodes an image for thumbnailing, applying orientation if necessary.
+func decodeSource(i *Image) (image.Image, error) {
+	f := formatFor(i.InPath)
+	if f == nil {
+		return nil, fmt.Errorf("unsupported format: %s", i.InPath)
+	}
+
+	img, err := f.Decode(i.InPath)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", f.Name, err)
+	}
+
+	if f.Oriented {
+		return img, nil
+	}
+	return applyOrientation(img, i.Orientation), nil
+}
+
+// publishRelPath returns the relative path an image is published to. Formats that
+// browsers can't display are published as a JPEG derivative alongside the original name,
+// and videos as an MP4 rendition if transcoding is enabled.
+func publishRelPath(i *Image, c *Config) string {
+	f := formatFor(i.InPath)
+	switch {
+	case f == nil:
+		return urlSafePath(i.RelPath)
+	case f.Video && c.TranscodeVideos:
+		return urlSafePath(i.RelPath + ".mp4")
+	case f.WebSafe:
+		return urlSafePath(i.RelPath)
+	default:
+		return urlSafePath(i.RelPath + ".jpg")
+	}
+}
+
+// decodeExternal decodes an image by converting it to a temporary JPEG with an external tool.
+func decodeExternal(path string) (image.Image, error) {
+	tmp, err := os.MkdirTemp("", "livstid")
+	if err != nil {
+		return nil, fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmp); err != nil {
+			klog.Errorf("Failed to remove %s: %v", tmp, err)
+		}
+	}()
+
+	out := filepath.Join(tmp, "out.jpg")
+	if err := convertToJPEG(path, out); err != nil {
+		return nil, err
+	}
+	return imgio.Open(out)
+}
+
+// convertToJPEG converts in to a JPEG file at out using the first available converter.
+func convertToJPEG(in string, out string) error {
+	for _, c := range Converters {
+		bin, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+
+		args := []string{}
+		for _, a := range c[1:] {
+			a = strings.ReplaceAll(a, "%in", in)
+			a = strings.ReplaceAll(a, "%out", out)
+			args = append(args, a)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ConvertTimeout)
+		cmd := exec.CommandContext(ctx, bin, args...)
+		klog.V(1).Infof("converting: %s", cmd)
+		bs, err := cmd.CombinedOutput()
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s failed: %w: %s", cmd, err, bs)
+		}
+		return nil
+	}
+	return errors.New("no image converter found in $PATH (tried magick, heif-convert, sips, convert)")
+}
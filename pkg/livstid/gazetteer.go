@@ -0,0 +1,254 @@
+package livstid
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// MaxPlaceDistance is the maximum distance in meters from a city for a photo to be labelled with it.
+	MaxPlaceDistance = 50000.0
+
+	gazetteersMu sync.Mutex
+	gazetteers   = map[string]*Gazetteer{}
+)
+
+// Place is a named location resolved from GPS coordinates.
+type Place struct {
+	City   string
+	Region string
+	// AdminCode is the GeoNames code of the region within its country, such as "08".
+	AdminCode   string
+	Country     string
+	CountryCode string
+	// TimeZone is the IANA time zone of the city, if known.
+	TimeZone string
+}
+
+// Tags returns the names of the city and country, for searching by tag.
+func (p *Place) Tags() []string {
+	if p == nil {
+		return nil
+	}
+	ts := []string{}
+	for _, s := range []string{p.City, p.Country} {
+		if s != "" {
+			ts = append(ts, s)
+		}
+	}
+	return ts
+}
+
+// String returns a human-readable name for the place.
+func (p *Place) String() string {
+	if p == nil {
+		return ""
+	}
+	parts := []string{}
+	for _, s := range []string{p.City, p.Region, p.Country} {
+		if s != "" && (len(parts) == 0 || parts[len(parts)-1] != s) {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type city struct {
+	Place
+	Coordinates
+}
+
+type gridKey struct {
+	lat int
+	lon int
+}
+
+// Gazetteer is an offline index of cities, loaded from a GeoNames cities dump
+// (such as cities1000.txt from https://download.geonames.org/export/dump/).
+type Gazetteer struct {
+	grid map[gridKey][]*city
+}
+
+// LoadGazetteer loads a GeoNames cities file. If admin1CodesASCII.txt or countryInfo.txt
+// exist in the same directory, they are used to name regions and countries. Gazetteers
+// are cached in memory, so repeated loads of the same path are cheap.
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	gazetteersMu.Lock()
+	defer gazetteersMu.Unlock()
+	if g, ok := gazetteers[path]; ok {
+		return g, nil
+	}
+
+	dir := filepath.Dir(path)
+	regions, err := readNames(filepath.Join(dir, "admin1CodesASCII.txt"), 1)
+	if err != nil {
+		return nil, fmt.Errorf("admin1 codes: %w", err)
+	}
+
+	countries, err := readNames(filepath.Join(dir, "countryInfo.txt"), 4)
+	if err != nil {
+		return nil, fmt.Errorf("country info: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			klog.Errorf("Failed to close file: %v", err)
+		}
+	}()
+
+	g := &Gazetteer{grid: map[gridKey][]*city{}}
+	n := 0
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		cols := strings.Split(s.Text(), "\t")
+		if len(cols) < 18 {
+			continue
+		}
+
+		lat, err := strconv.ParseFloat(cols[4], 64)
+		if err != nil {
+			continue
+		}
+		lon, err := strconv.ParseFloat(cols[5], 64)
+		if err != nil {
+			continue
+		}
+
+		cc := cols[8]
+		c := &city{
+			Coordinates: Coordinates{Latitude: lat, Longitude: lon},
+			Place: Place{
+				City:        cols[1],
+				Region:      regions[cc+"."+cols[10]],
+				AdminCode:   cols[10],
+				Country:     countries[cc],
+				CountryCode: cc,
+				TimeZone:    cols[17],
+			},
+		}
+		if c.Country == "" {
+			c.Country = cc
+		}
+
+		k := gridFor(lat, lon)
+		g.grid[k] = append(g.grid[k], c)
+		n++
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	klog.Infof("loaded %d cities from %s", n, path)
+	gazetteers[path] = g
+	return g, nil
+}
+
+// readNames reads a tab-separated GeoNames file into a map of the first column to column n.
+// Missing files return an empty map.
+func readNames(path string, n int) (map[string]string, error) {
+	names := map[string]string{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		klog.V(1).Infof("%s not found, skipping", path)
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			klog.Errorf("Failed to close file: %v", err)
+		}
+	}()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		cols := strings.Split(line, "\t")
+		if len(cols) > n {
+			names[cols[0]] = cols[n]
+		}
+	}
+	return names, s.Err()
+}
+
+func gridFor(lat float64, lon float64) gridKey {
+	return gridKey{lat: int(math.Floor(lat)), lon: int(math.Floor(lon))}
+}
+
+// Lookup returns the nearest city to c, or nil if none is within MaxPlaceDistance.
+func (g *Gazetteer) Lookup(c *Coordinates) *Place {
+	if g == nil || c == nil {
+		return nil
+	}
+
+	k := gridFor(c.Latitude, c.Longitude)
+	var best *city
+	bestDist := MaxPlaceDistance
+
+	for dlat := -1; dlat <= 1; dlat++ {
+		for dlon := -1; dlon <= 1; dlon++ {
+			lon := k.lon + dlon
+			// wrap around the antimeridian
+			if lon < -180 {
+				lon += 360
+			}
+			if lon >= 180 {
+				lon -= 360
+			}
+			for _, ct := range g.grid[gridKey{lat: k.lat + dlat, lon: lon}] {
+				d := c.Distance(&ct.Coordinates)
+				if d < bestDist {
+					best = ct
+					bestDist = d
+				}
+			}
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	p := best.Place
+	return &p
+}
+
+// geocode labels geotagged images with the nearest place from the configured gazetteer.
+func geocode(is []*Image, c *Config) error {
+	if c.Gazetteer == "" {
+		return nil
+	}
+
+	g, err := LoadGazetteer(c.Gazetteer)
+	if err != nil {
+		return fmt.Errorf("load gazetteer: %w", err)
+	}
+
+	n := 0
+	for _, i := range is {
+		i.Place = g.Lookup(i.GPS)
+		if i.Place != nil {
+			klog.V(1).Infof("%s: %s", i.InPath, i.Place)
+			n++
+		}
+	}
+	klog.Infof("resolved places for %d of %d images", n, len(is))
+	return nil
+}
@@ -0,0 +1,257 @@
+package livstid
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/barasher/go-exiftool"
+	"k8s.io/klog/v2"
+)
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371000.0
+
+// Coordinates are a GPS location.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+	// Altitude is in meters above sea level.
+	Altitude float64
+}
+
+// Distance returns the great-circle distance in meters between two coordinates.
+func (c *Coordinates) Distance(o *Coordinates) float64 {
+	lat1 := c.Latitude * math.Pi / 180
+	lat2 := o.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (o.Longitude - c.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// readGPS extracts coordinates from exiftool output, which is configured to print signed decimal degrees.
+func readGPS(fi exiftool.FileMetadata) *Coordinates {
+	pos, err := fi.GetString("GPSPosition")
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.Split(pos, ",")
+	if len(parts) != 2 {
+		klog.Warningf("unexpected GPSPosition: %q", pos)
+		return nil
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		klog.Warningf("unable to parse latitude %q: %v", parts[0], err)
+		return nil
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		klog.Warningf("unable to parse longitude %q: %v", parts[1], err)
+		return nil
+	}
+
+	// cameras without a fix often record 0,0
+	if lat == 0 && lon == 0 {
+		return nil
+	}
+
+	c := &Coordinates{Latitude: lat, Longitude: lon}
+	if as, err := fi.GetString("GPSAltitude"); err == nil {
+		c.Altitude = parseAltitude(as)
+	}
+	return c
+}
+
+// parseAltitude parses altitudes as printed by exiftool, such as "12.3 m Below Sea Level".
+func parseAltitude(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	a, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		klog.V(1).Infof("unable to parse altitude %q: %v", s, err)
+		return 0
+	}
+	if strings.Contains(s, "Below") {
+		a = -a
+	}
+	return a
+}
+
+// geoJSON is a GeoJSON FeatureCollection.
+type geoJSON struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+// geoFeature is a GeoJSON Feature.
+type geoFeature struct {
+	Properties map[string]any `json:"properties"`
+	Geometry   geoGeometry    `json:"geometry"`
+	Type       string         `json:"type"`
+}
+
+// geoGeometry is a GeoJSON Geometry.
+type geoGeometry struct {
+	Coordinates any    `json:"coordinates"`
+	Type        string `json:"type"`
+}
+
+func pointFeature(c *Coordinates, props map[string]any) geoFeature {
+	return geoFeature{
+		Type:       "Feature",
+		Geometry:   geoGeometry{Type: "Point", Coordinates: []float64{c.Longitude, c.Latitude, c.Altitude}},
+		Properties: props,
+	}
+}
+
+// trackFeature returns a line along the points of a track.
+func trackFeature(t *Track) geoFeature {
+	cs := make([][]float64, 0, len(t.Points))
+	for _, p := range t.Points {
+		cs = append(cs, []float64{p.Longitude, p.Latitude, p.Altitude})
+	}
+	return geoFeature{
+		Type:       "Feature",
+		Geometry:   geoGeometry{Type: "LineString", Coordinates: cs},
+		Properties: map[string]any{"kind": "track", "title": t.Name},
+	}
+}
+
+// relURL returns a forward-slashed relative URL from the base directory to path.
+func relURL(base string, path string) string {
+	r, err := filepath.Rel(base, path)
+	if err != nil {
+		return fmt.Sprintf("ERROR[%v]", err)
+	}
+	return filepath.ToSlash(r)
+}
+
+// viewerURL returns a URL, relative to base, that opens an image in its album's nanogallery viewer.
+func viewerURL(base string, i *Image) string {
+	dir := filepath.Dir(i.OutPath)
+	if i.Page > 1 {
+		dir = filepath.Join(dir, "page", strconv.Itoa(i.Page))
+	}
+	gallery := "0"
+	if i.stackID != "" {
+		gallery = i.stackID
+	}
+	return relURL(base, dir) + "/#nanogallery/i/" + gallery + "/" + i.BasePath
+}
+
+// centroid returns the average location of geotagged images, or nil if there are none.
+func centroid(is []*Image) *Coordinates {
+	var lat, lon, alt float64
+	n := 0
+	for _, i := range is {
+		if i.GPS == nil {
+			continue
+		}
+		lat += i.GPS.Latitude
+		lon += i.GPS.Longitude
+		alt += i.GPS.Altitude
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	return &Coordinates{Latitude: lat / float64(n), Longitude: lon / float64(n), Altitude: alt / float64(n)}
+}
+
+// photoFeatures returns a point for each geotagged image in an album, with URLs relative to base.
+func photoFeatures(a *Album, base string) []geoFeature {
+	fs := []geoFeature{}
+	for _, i := range a.Images {
+		if i.GPS == nil {
+			continue
+		}
+		props := map[string]any{
+			"kind":  "photo",
+			"title": i.Title,
+			"url":   viewerURL(base, i),
+			"album": a.Title,
+		}
+		if t, ok := i.Resize["Tiny"]; ok {
+			props["thumb"] = relURL(base, t.Path)
+		}
+		if !i.Taken.IsZero() {
+			props["taken"] = i.Taken.Format(ThumbDateFormat)
+		}
+		if i.Place != nil {
+			props["place"] = i.Place.String()
+		}
+		fs = append(fs, pointFeature(i.GPS, props))
+	}
+	return fs
+}
+
+// albumFeature returns a point at the centroid of an album, or false if it has no geotagged images.
+func albumFeature(a *Album, base string) (geoFeature, bool) {
+	c := centroid(a.Images)
+	if c == nil {
+		return geoFeature{}, false
+	}
+	return pointFeature(c, map[string]any{
+		"kind":  "album",
+		"title": a.Title,
+		"url":   relURL(base, a.OutPath) + "/",
+		"count": len(a.Images),
+	}), true
+}
+
+// albumGeoJSON returns a GeoJSON collection for a single album and its tracks, with URLs relative to base.
+func albumGeoJSON(a *Album, base string) *geoJSON {
+	g := &geoJSON{Type: "FeatureCollection", Features: photoFeatures(a, base)}
+	for _, t := range a.Tracks {
+		g.Features = append(g.Features, trackFeature(t))
+	}
+	if f, ok := albumFeature(a, base); ok {
+		g.Features = append(g.Features, f)
+	}
+	return g
+}
+
+// assemblyGeoJSON returns a GeoJSON collection of every album and geotagged photo, with URLs relative to base.
+func assemblyGeoJSON(as *Assembly, base string) *geoJSON {
+	g := &geoJSON{Type: "FeatureCollection", Features: []geoFeature{}}
+	for _, a := range as.Albums {
+		if a.Hidden {
+			continue
+		}
+		g.Features = append(g.Features, photoFeatures(a, base)...)
+		if f, ok := albumFeature(a, base); ok {
+			g.Features = append(g.Features, f)
+		}
+	}
+	return g
+}
+
+// writeGeoJSON writes a GeoJSON collection to path, returning the encoded bytes.
+func writeGeoJSON(path string, g *geoJSON) ([]byte, error) {
+	bs, err := json.Marshal(g)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	klog.V(1).Infof("Writing %d features to %s", len(g.Features), path)
+	if err := os.WriteFile(path, bs, 0o644); err != nil { //nolint:gosec // file permissions are standard
+		return nil, fmt.Errorf("write file: %w", err)
+	}
+	return bs, nil
+}
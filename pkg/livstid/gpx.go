@@ -0,0 +1,385 @@
+package livstid
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// defaultGPXMaxGap is how far in time a photo may be from a track point to be geotagged by it.
+	defaultGPXMaxGap = 5 * time.Minute
+	// trackResolution is the distance in meters between the points of tracks drawn on maps.
+	trackResolution = 10.0
+)
+
+// TrackPoint is a timestamped location within a GPX track.
+type TrackPoint struct {
+	Time time.Time
+	Coordinates
+	// Elevated is set if the altitude was recorded by the GPX file.
+	Elevated bool
+}
+
+// Track is a continuous segment of a GPX track, ordered by time.
+type Track struct {
+	Name   string
+	Points []TrackPoint
+}
+
+// gpxFile is the subset of a GPX document which livstid uses.
+type gpxFile struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// gpxPoint is a GPX track point.
+type gpxPoint struct {
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+	Time      string   `xml:"time"`
+}
+
+// LoadTracks reads the track segments of GPX files, and of the GPX files within directories.
+func LoadTracks(paths []string) ([]*Track, error) {
+	ts := []*Track{}
+	for _, p := range paths {
+		files := []string{p}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("stat: %w", err)
+		}
+		if fi.IsDir() {
+			files, err = gpxFiles(p)
+			if err != nil {
+				return nil, fmt.Errorf("walk %s: %w", p, err)
+			}
+		}
+
+		for _, f := range files {
+			t, err := readGPX(f)
+			if err != nil {
+				return nil, fmt.Errorf("gpx %s: %w", f, err)
+			}
+			ts = append(ts, t...)
+		}
+	}
+	return ts, nil
+}
+
+// gpxFiles returns the GPX files within a directory.
+func gpxFiles(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".gpx") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readGPX returns the track segments of a GPX file. Points without a time are skipped.
+func readGPX(path string) ([]*Track, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+
+	g := &gpxFile{}
+	if err := xml.Unmarshal(bs, g); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	ts := []*Track{}
+	for _, trk := range g.Tracks {
+		name := trk.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		for _, seg := range trk.Segments {
+			t := &Track{Name: name}
+			for _, p := range seg.Points {
+				tm, err := time.Parse(time.RFC3339, strings.TrimSpace(p.Time))
+				if err != nil {
+					klog.V(1).Infof("%s: skipping track point with time %q: %v", path, p.Time, err)
+					continue
+				}
+				tp := TrackPoint{Time: tm, Coordinates: Coordinates{Latitude: p.Latitude, Longitude: p.Longitude}}
+				if p.Elevation != nil {
+					tp.Altitude, tp.Elevated = *p.Elevation, true
+				}
+				t.Points = append(t.Points, tp)
+			}
+			if len(t.Points) == 0 {
+				continue
+			}
+			sort.SliceStable(t.Points, func(i, j int) bool { return t.Points[i].Time.Before(t.Points[j].Time) })
+			ts = append(ts, t)
+		}
+	}
+	klog.Infof("%s: found %d track segments", path, len(ts))
+	return ts, nil
+}
+
+// At returns the location of the track at tm, and how far in time it is from the nearest track
+// point. Locations between points no more than maxGap apart are interpolated; otherwise the nearest
+// point is used if it is within maxGap of tm.
+func (t *Track) At(tm time.Time, maxGap time.Duration) (*TrackPoint, time.Duration, bool) {
+	ps := t.Points
+	n := sort.Search(len(ps), func(k int) bool { return !ps[k].Time.Before(tm) })
+
+	var p TrackPoint
+	var gap time.Duration
+	switch {
+	case n < len(ps) && ps[n].Time.Equal(tm):
+		p = ps[n]
+	case n == 0:
+		p, gap = ps[0], ps[0].Time.Sub(tm)
+	case n == len(ps):
+		p, gap = ps[n-1], tm.Sub(ps[n-1].Time)
+	default:
+		p0, p1 := ps[n-1], ps[n]
+		before, after := tm.Sub(p0.Time), p1.Time.Sub(tm)
+		gap = min(before, after)
+		switch {
+		case p1.Time.Sub(p0.Time) <= maxGap:
+			f := float64(before) / float64(p1.Time.Sub(p0.Time))
+			p.Coordinates = Coordinates{
+				Latitude:  p0.Latitude + f*(p1.Latitude-p0.Latitude),
+				Longitude: p0.Longitude + f*(p1.Longitude-p0.Longitude),
+				Altitude:  p0.Altitude + f*(p1.Altitude-p0.Altitude),
+			}
+			p.Elevated = p0.Elevated && p1.Elevated
+		case before <= after:
+			p = p0
+		default:
+			p = p1
+		}
+	}
+
+	if gap > maxGap {
+		return nil, 0, false
+	}
+	p.Time = tm
+	return &p, gap, true
+}
+
+// Clip returns the part of the track between start and end, with points closer together than
+// trackResolution dropped, or nil if fewer than two points remain.
+func (t *Track) Clip(start, end time.Time) *Track {
+	c := &Track{Name: t.Name}
+	for _, p := range t.Points {
+		if p.Time.Before(start) || p.Time.After(end) {
+			continue
+		}
+		if n := len(c.Points); n > 0 && c.Points[n-1].Distance(&p.Coordinates) < trackResolution {
+			continue
+		}
+		c.Points = append(c.Points, p)
+	}
+	if len(c.Points) < 2 {
+		return nil
+	}
+	return c
+}
+
+// gpxMaxGap returns the configured maximum gap, or the default.
+func gpxMaxGap(c *Config) time.Duration {
+	if c.GPXMaxGap <= 0 {
+		return defaultGPXMaxGap
+	}
+	return c.GPXMaxGap
+}
+
+// trackTime returns the instant an image was taken at. Capture times which don't record a zone
+// are interpreted in loc.
+func trackTime(i *Image, loc *time.Location) time.Time {
+	if i.Zoned {
+		return i.Taken
+	}
+	return inZone(i.Taken, loc)
+}
+
+// locate returns the location of the track point nearest in time to t, interpolated if possible.
+func locate(ts []*Track, t time.Time, maxGap time.Duration) *TrackPoint {
+	var best *TrackPoint
+	var bestGap time.Duration
+	for _, tr := range ts {
+		p, gap, ok := tr.At(t, maxGap)
+		if ok && (best == nil || gap < bestGap) {
+			best, bestGap = p, gap
+		}
+	}
+	return best
+}
+
+// geotag is an image located by a GPX track.
+type geotag struct {
+	i *Image
+	// elevated is set if the track recorded the altitude of the location.
+	elevated bool
+}
+
+// geotagFromTracks sets the location of images which have none from GPX tracks, returning the
+// images which were geotagged. Capture times which don't record a zone are interpreted in their
+// album's time zone, then the display time zone, then the local time zone.
+func geotagFromTracks(is []*Image, ts []*Track, loc *time.Location, c *Config) []geotag {
+	if len(ts) == 0 {
+		return nil
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	maxGap := gpxMaxGap(c)
+	albumLocs := map[string]*time.Location{}
+	tagged := []geotag{}
+	for _, i := range is {
+		if i.GPS != nil || i.Taken.IsZero() {
+			continue
+		}
+
+		dir := filepath.Dir(i.InPath)
+		al, ok := albumLocs[dir]
+		if !ok {
+			al = loc
+			m, err := readAlbumMeta(dir)
+			if err != nil {
+				klog.Errorf("album metadata: %v", err)
+			}
+			if m != nil && m.location != nil {
+				al = m.location
+			}
+			albumLocs[dir] = al
+		}
+
+		if p := locate(ts, trackTime(i, al), maxGap); p != nil {
+			i.GPS = &p.Coordinates
+			klog.V(1).Infof("%s: geotagged from GPX at %.6f,%.6f", i.InPath, i.GPS.Latitude, i.GPS.Longitude)
+			tagged = append(tagged, geotag{i: i, elevated: p.Elevated})
+		}
+	}
+	klog.Infof("geotagged %d images from %d GPX track segments", len(tagged), len(ts))
+	return tagged
+}
+
+// attachTracks adds the parts of tracks recorded while each album's photos were taken to the album.
+// Capture times which don't record a zone are interpreted in loc, or the local time zone.
+func attachTracks(albums map[string]*Album, ts []*Track, loc *time.Location, c *Config) {
+	if len(ts) == 0 {
+		return
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	maxGap := gpxMaxGap(c)
+	for _, a := range albums {
+		var start, end time.Time
+		for _, i := range a.Images {
+			if i.Taken.IsZero() {
+				continue
+			}
+			t := trackTime(i, loc)
+			if start.IsZero() || t.Before(start) {
+				start = t
+			}
+			if t.After(end) {
+				end = t
+			}
+		}
+		if start.IsZero() {
+			continue
+		}
+
+		for _, t := range ts {
+			if ct := t.Clip(start.Add(-maxGap), end.Add(maxGap)); ct != nil {
+				a.Tracks = append(a.Tracks, ct)
+			}
+		}
+	}
+}
+
+// writeGPS writes the locations of geotagged images to their originals, or to their XMP sidecars if
+// sidecar is set. Altitudes are only written if the track recorded them.
+func writeGPS(gs []geotag, sidecar bool) []error {
+	if len(gs) == 0 {
+		return nil
+	}
+
+	et, err := exiftool.NewExiftool()
+	if err != nil {
+		return []error{fmt.Errorf("exiftool: %w", err)}
+	}
+	defer func() {
+		if err := et.Close(); err != nil {
+			klog.Errorf("Failed to close exiftool: %v", err)
+		}
+	}()
+
+	errs := []error{}
+	for _, g := range gs {
+		i := g.i
+		path := i.InPath
+		if sidecar {
+			path, err = ensureSidecar(i.InPath)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", i.InPath, err))
+				continue
+			}
+		}
+
+		// exiftool derives the reference direction from the sign
+		lat := strconv.FormatFloat(i.GPS.Latitude, 'f', 6, 64)
+		lon := strconv.FormatFloat(i.GPS.Longitude, 'f', 6, 64)
+		alt := strconv.FormatFloat(i.GPS.Altitude, 'f', 1, 64)
+		fm := exiftool.EmptyFileMetadata()
+		fm.File = path
+		if i.Video && !sidecar {
+			cs := lat + ", " + lon
+			if g.elevated {
+				cs += ", " + alt
+			}
+			fm.SetString("GPSCoordinates", cs)
+		} else {
+			fm.SetString("GPSLatitude", lat)
+			fm.SetString("GPSLongitude", lon)
+			// XMP records the direction within the latitude and longitude, but EXIF needs reference tags
+			if !sidecar {
+				fm.SetString("GPSLatitudeRef", lat)
+				fm.SetString("GPSLongitudeRef", lon)
+			}
+			if g.elevated {
+				fm.SetString("GPSAltitude", alt)
+				fm.SetString("GPSAltitudeRef", alt)
+			}
+		}
+
+		fms := []exiftool.FileMetadata{fm}
+		et.WriteMetadata(fms)
+		if fms[0].Err != nil {
+			errs = append(errs, fmt.Errorf("write %s: %w", path, fms[0].Err))
+			continue
+		}
+		klog.Infof("%s: wrote GPX location", path)
+	}
+	return errs
+}
@@ -0,0 +1,115 @@
+package livstid
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackAt(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tr := &Track{Points: []TrackPoint{
+		{Time: t0, Coordinates: Coordinates{Latitude: 52, Longitude: 4, Altitude: 10}, Elevated: true},
+		{Time: t0.Add(2 * time.Minute), Coordinates: Coordinates{Latitude: 53, Longitude: 5, Altitude: 30}, Elevated: true},
+		{Time: t0.Add(time.Hour), Coordinates: Coordinates{Latitude: 54, Longitude: 6}},
+	}}
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		wantOK   bool
+		lat, lon float64
+		gap      time.Duration
+		elevated bool
+	}{
+		{name: "on a point", at: 0, wantOK: true, lat: 52, lon: 4, elevated: true},
+		{name: "interpolated", at: time.Minute, wantOK: true, lat: 52.5, lon: 4.5, gap: time.Minute, elevated: true},
+		{name: "before the track", at: -time.Minute, wantOK: true, lat: 52, lon: 4, gap: time.Minute, elevated: true},
+		{name: "long before the track", at: -time.Hour, wantOK: false},
+		{name: "near a point across a gap", at: 3 * time.Minute, wantOK: true, lat: 53, lon: 5, gap: time.Minute, elevated: true},
+		{name: "within a gap", at: 30 * time.Minute, wantOK: false},
+		{name: "after the track", at: 62 * time.Minute, wantOK: true, lat: 54, lon: 6, gap: 2 * time.Minute},
+	}
+	for _, tc := range tests {
+		p, gap, ok := tr.At(t0.Add(tc.at), 5*time.Minute)
+		if ok != tc.wantOK {
+			t.Errorf("%s: ok = %v, want %v", tc.name, ok, tc.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(p.Latitude-tc.lat) > 1e-9 || math.Abs(p.Longitude-tc.lon) > 1e-9 {
+			t.Errorf("%s: at %f,%f, want %f,%f", tc.name, p.Latitude, p.Longitude, tc.lat, tc.lon)
+		}
+		if gap != tc.gap {
+			t.Errorf("%s: gap = %s, want %s", tc.name, gap, tc.gap)
+		}
+		if p.Elevated != tc.elevated {
+			t.Errorf("%s: elevated = %v, want %v", tc.name, p.Elevated, tc.elevated)
+		}
+		if !p.Time.Equal(t0.Add(tc.at)) {
+			t.Errorf("%s: time = %s, want %s", tc.name, p.Time, t0.Add(tc.at))
+		}
+	}
+}
+
+func TestReadGPX(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "walk.gpx")
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1">
+  <trk>
+    <trkseg>
+      <trkpt lat="52.1" lon="4.1"><ele>3.5</ele><time>2024-06-01T12:01:00Z</time></trkpt>
+      <trkpt lat="52.0" lon="4.0"><time>2024-06-01T12:00:00Z</time></trkpt>
+      <trkpt lat="52.2" lon="4.2"><time>yesterday</time></trkpt>
+    </trkseg>
+    <trkseg></trkseg>
+  </trk>
+  <trk>
+    <name>Ferry</name>
+    <trkseg>
+      <trkpt lat="51.0" lon="3.0"><time>2024-06-02T08:00:00+02:00</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+	if err := os.WriteFile(path, []byte(gpx), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := readGPX(path)
+	if err != nil {
+		t.Fatalf("readGPX: %v", err)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("found %d tracks, want 2", len(ts))
+	}
+
+	tests := []struct {
+		track    *Track
+		name     string
+		points   int
+		lat      float64
+		elevated bool
+	}{
+		{track: ts[0], name: "walk", points: 2, lat: 52.0},
+		{track: ts[1], name: "Ferry", points: 1, lat: 51.0},
+	}
+	for _, tc := range tests {
+		if tc.track.Name != tc.name {
+			t.Errorf("track named %q, want %q", tc.track.Name, tc.name)
+		}
+		if len(tc.track.Points) != tc.points {
+			t.Errorf("%s has %d points, want %d", tc.name, len(tc.track.Points), tc.points)
+			continue
+		}
+		if p := tc.track.Points[0]; p.Latitude != tc.lat || p.Elevated != tc.elevated {
+			t.Errorf("%s starts at %f (elevated %v), want %f (elevated %v)", tc.name, p.Latitude, p.Elevated, tc.lat, tc.elevated)
+		}
+	}
+	if p := ts[0].Points[1]; !p.Elevated || p.Altitude != 3.5 {
+		t.Errorf("second point of walk has altitude %f (elevated %v), want 3.5", p.Altitude, p.Elevated)
+	}
+}
@@ -14,12 +14,25 @@ type ThumbMeta struct {
 
 // Image represents a photo with its metadata.
 type Image struct {
-	ModTime     time.Time
-	Taken       time.Time
-	Resize      map[string]ThumbMeta
-	BasePath    string
-	RelPath     string
-	InPath      string
+	ModTime time.Time
+	Taken   time.Time
+	// Zoned is set if Taken is known to be in the zone it was taken in, rather than being a wall
+	// clock time in an unknown zone.
+	Zoned bool
+	// UTC is set if Taken is known in UTC, such as the creation time of a video, but not the zone it
+	// was taken in.
+	UTC bool
+	// Duration is the length of a video.
+	Duration time.Duration
+	Resize   map[string]ThumbMeta
+	GPS      *Coordinates
+	// Place is the nearest named place to GPS, if a gazetteer is configured.
+	Place    *Place
+	BasePath string
+	RelPath  string
+	InPath   string
+	// RawPath is the RAW file paired with this image, if any.
+	RawPath     string
 	FocalLength string
 	OutPath     string
 	Speed       string
@@ -27,15 +40,43 @@ type Image struct {
 	Description string
 	Make        string
 	Model       string
-	LensMake    string
-	LensModel   string
-	Hier        []string
-	Keywords    []string
-	Aperture    float64
-	ISO         int64
-	Width       int64
-	Height      int64
-	Highlight   bool
+	// Serial is the serial number of the camera body.
+	Serial    string
+	LensMake  string
+	LensModel string
+	Hier      []string
+	Keywords  []string
+	Aperture  float64
+	ISO       int64
+	Width     int64
+	Height    int64
+	// Orientation is the EXIF orientation (1-8). Width and Height are already adjusted for it.
+	Orientation int
+	// Hash is the SHA-256 of the file's content, if computed.
+	Hash string
+	// DHash is the hexadecimal perceptual difference hash of the image, if computed.
+	DHash string
+	// Sharpness measures the fine detail in the image, if computed along with DHash.
+	Sharpness float64
+	// HasSharpness is set once Sharpness has been computed, as a featureless image measures 0.
+	HasSharpness bool
+	// Rating is the XMP star rating (0-5), or RatingRejected.
+	Rating int
+	// HasRating is set if a rating was recorded, so that a sidecar's 0 stars overrides an embedded rating.
+	HasRating bool
+	// Label is the XMP color label, such as "Red".
+	Label string
+	// People are the names of people in the image, from face regions.
+	People []string
+	// Page is the page of its directory album that the image is rendered on, starting at 1.
+	Page int
+	// stackID is the gallery album ID of the burst containing the image in its directory album, if any.
+	stackID string
+	// Highlight is set for images rated at least Config.HighlightRating.
+	Highlight bool
+	// Favorite is set for images selected by Config.Favorites, or favorited in Google Photos.
+	Favorite bool
+	Video    bool
 }
 
 // Album represents a collection of images.
@@ -50,6 +91,19 @@ type Album struct {
 	Description string
 	Hier        []string
 	Images      []*Image
-	HierLevel   int
-	Hidden      bool
+	// Cover is the image chosen to represent the album, if any.
+	Cover *Image
+	// Children are the albums nested within this album's directory.
+	Children []*AlbumNode
+	// Tracks are the parts of GPX tracks recorded while the album's photos were taken.
+	Tracks    []*Track
+	HierLevel int
+	// PageSize is the number of images per rendered page, or 0 for a single page.
+	PageSize int
+	Hidden   bool
+	meta     *AlbumMeta
+	// crumbs are the names of the directories in Hier for navigation, if any are labeled.
+	crumbs []string
+	// stacks maps the representative of each burst in the album to its other frames.
+	stacks map[*Image][]*Image
 }
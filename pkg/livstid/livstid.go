@@ -1,20 +1,92 @@
 package livstid
 
+import "time"
+
 // Config holds configuration for livstid.
 type Config struct {
-	Thumbnails      map[string]ThumbOpts
-	OutDir          string
-	Collection      string
-	Description     string
-	RCloneTarget    string
-	InDirs          []string
-	ProcessSidecars bool
+	Thumbnails   map[string]ThumbOpts `yaml:"thumbnails"`
+	OutDir       string               `yaml:"out"`
+	Collection   string               `yaml:"title"`
+	Description  string               `yaml:"description"`
+	RCloneTarget string               `yaml:"rclone"`
+	CacheDir     string               `yaml:"cache_dir"`
+	// Gazetteer is the path to a GeoNames cities file used for offline reverse geocoding.
+	Gazetteer string `yaml:"gazetteer"`
+	// GPSPolicy controls published locations: "keep" (default), "round" or "strip".
+	GPSPolicy string   `yaml:"gps_policy"`
+	InDirs    []string `yaml:"in"`
+	// Roots are input directories with an optional prefix and label, processed after InDirs.
+	Roots []Root `yaml:"roots"`
+	// TopLevelAlbum publishes photos at the top of an input directory in an album named after it.
+	// Otherwise they are published at the top of the site, in no album. Enabling it changes their URLs.
+	TopLevelAlbum bool `yaml:"top_level_album"`
+	// RootMode is "merge" (default), which combines same-named directories across roots into one
+	// album, or "separate", which places each root under a prefix of its own.
+	RootMode string `yaml:"root_mode"`
+	// Metadata limits the metadata in published files. If nil, originals are published with all of their metadata.
+	Metadata *MetadataPolicy `yaml:"metadata"`
+	// PrivacyZones are areas in which locations are never published.
+	PrivacyZones []PrivacyZone `yaml:"privacy_zones"`
+	// Workers is the number of concurrent thumbnail workers. Defaults to GOMAXPROCS.
+	Workers int `yaml:"workers"`
+	// GPSPrecision is the number of decimal places kept by the "round" GPS policy, where 0 keeps
+	// whole degrees. Defaults to 2 (~1km).
+	GPSPrecision *int `yaml:"gps_precision"`
+	// ProcessSidecars applies Google Takeout JSON sidecars. XMP sidecars are always applied.
+	ProcessSidecars bool `yaml:"process_sidecars"`
+	// WriteSidecars makes writers, such as GPX geotagging and manage mode, update XMP sidecars rather than originals.
+	WriteSidecars bool `yaml:"write_sidecars"`
+	RebuildCache  bool `yaml:"-"`
+	// TranscodeVideos publishes videos as an H.264 MP4 rendition rather than the original file.
+	TranscodeVideos bool `yaml:"transcode_video"`
+	// Dedupe selects how duplicate photos are detected: "exact" (default, identical files),
+	// "perceptual" (also visually similar photos, such as resized copies), or "off".
+	Dedupe string `yaml:"dedupe"`
+	// DedupeThreshold is the number of perceptual hash bits in which duplicates may differ. Defaults to 4.
+	DedupeThreshold int `yaml:"dedupe_threshold"`
+	// HighlightRating is the star rating at which images are highlighted. If zero, ratings don't highlight images.
+	HighlightRating int `yaml:"highlight_rating"`
+	// Favorites selects favorite images. Defaults to images with the "fav" keyword.
+	Favorites *FavoriteRule `yaml:"favorites"`
+	// StackWindow is the time within which consecutive similar frames are stacked as a burst.
+	// If zero, bursts are not stacked.
+	StackWindow time.Duration `yaml:"stack_window"`
+	// StackThreshold is the number of perceptual hash bits in which stacked frames may differ. Defaults to 12.
+	StackThreshold int `yaml:"stack_threshold"`
+	// AlbumPageSize is the number of images per page of an album. Defaults to 30.
+	AlbumPageSize int `yaml:"album_page_size"`
+	// RecentSize is the number of images in the recent album. Defaults to 30.
+	RecentSize int `yaml:"recent_size"`
+	// MinAlbumSize is the number of images an album needs to be published. Defaults to 4.
+	MinAlbumSize int `yaml:"min_album_size"`
+	// IndexSort is the order of albums in the index: "path" (default, descending) or "date" (newest first).
+	IndexSort string `yaml:"index_sort"`
+	// YearReviewSize is the number of images in each year in review album. Defaults to 60.
+	YearReviewSize int `yaml:"year_review_size"`
+	// ClockCorrections adjust the capture times of photos from cameras whose clocks were wrong.
+	ClockCorrections []ClockCorrection `yaml:"clock_corrections"`
+	// DisplayTimezone is the IANA time zone, such as "Europe/Amsterdam", which capture times are shown in.
+	// If empty, each photo is shown in the zone it was taken in. Times which don't record a zone are
+	// assumed to be in it.
+	DisplayTimezone string `yaml:"display_timezone"`
+	// GPX are GPX files, or directories of them, whose tracks geotag photos without a location and
+	// are drawn on album maps.
+	GPX []string `yaml:"gpx"`
+	// GPXMaxGap is how far in time a photo may be from a track point to be geotagged by it, and the
+	// longest gap between track points which is interpolated across. Defaults to 5m.
+	GPXMaxGap time.Duration `yaml:"gpx_max_gap"`
+	// WriteGPS writes locations found in GPX tracks back to originals, or to XMP sidecars with WriteSidecars.
+	WriteGPS bool `yaml:"write_gps"`
+	// EventGap is the time between photos which starts a new event. If zero, events are not detected.
+	EventGap time.Duration `yaml:"event_gap"`
+	// EventDistance is the distance in meters between photos which starts a new event. Defaults to 100km.
+	EventDistance float64 `yaml:"event_distance"`
 }
 
-// TakeoutSidecar is a JSON file for EXIF overrides that is compatible with Google Takeout.
-type TakeoutSidecar struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	// Not compatible
-	Tags []string `json:"tags"`
+// orDefault returns v, or def if v is unset.
+func orDefault(v int, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
 }
@@ -0,0 +1,291 @@
+package livstid
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/karrick/godirwalk"
+	"k8s.io/klog/v2"
+)
+
+// MetadataPolicy lists the metadata kept in published files, as exiftool tag names.
+// Entries may be qualified by group ("EXIF:Copyright"), and "all" matches every tag in
+// a group ("XMP-dc:all"). When a GPS policy or privacy zones are set, they govern any
+// locations kept by this list.
+type MetadataPolicy struct {
+	// Originals are kept in the full-size published copy.
+	Originals []string `yaml:"originals"`
+	// Thumbnails are copied from the original into thumbnails, which otherwise have no metadata.
+	Thumbnails []string `yaml:"thumbnails"`
+}
+
+// DefaultMetadataPolicy keeps what the site displays, along with credits and captions.
+// Serial numbers, software, owner names, maker notes and face regions are removed.
+var DefaultMetadataPolicy = MetadataPolicy{
+	Originals: []string{
+		"EXIF:DateTimeOriginal", "EXIF:OffsetTimeOriginal",
+		"EXIF:Make", "EXIF:Model", "EXIF:LensMake", "EXIF:LensModel",
+		"EXIF:ExposureTime", "EXIF:FNumber", "EXIF:ApertureValue", "EXIF:ISO", "EXIF:FocalLength",
+		"EXIF:Orientation", "EXIF:ColorSpace", "ICC_Profile:all",
+		"EXIF:Artist", "EXIF:Copyright", "EXIF:ImageDescription",
+		"IPTC:By-line", "IPTC:CopyrightNotice", "IPTC:Headline", "IPTC:Caption-Abstract", "IPTC:Keywords",
+		"XMP-dc:Creator", "XMP-dc:Rights", "XMP-dc:Title", "XMP-dc:Description", "XMP-dc:Subject",
+		"XMP-photoshop:Headline",
+	},
+	Thumbnails: []string{
+		"EXIF:Artist", "EXIF:Copyright", "EXIF:ImageDescription",
+		"IPTC:By-line", "IPTC:CopyrightNotice", "IPTC:Caption-Abstract",
+		"XMP-dc:Creator", "XMP-dc:Rights", "XMP-dc:Title", "XMP-dc:Description",
+	},
+}
+
+var (
+	// intrinsicGroups describe the file itself rather than the photo, and are never audited.
+	intrinsicGroups = map[string]bool{
+		"exiftool": true, "file": true, "system": true, "composite": true,
+		"jfif": true, "png": true, "riff": true, "quicktime": true,
+	}
+
+	// metadataGroups are subgroups of intrinsic groups which hold user metadata.
+	metadataGroups = map[string]bool{"userdata": true, "keys": true, "itemlist": true}
+
+	// mandatoryTags are written by exiftool whenever it creates a metadata segment.
+	mandatoryTags = map[string]bool{
+		"xresolution": true, "yresolution": true, "resolutionunit": true, "ycbcrpositioning": true,
+		"exifversion": true, "componentsconfiguration": true, "flashpixversion": true,
+		"exifimagewidth": true, "exifimageheight": true, "xmptoolkit": true,
+		"applicationrecordversion": true, "currentiptcdigest": true, "iptcdigest": true,
+	}
+
+	// gpsGroups are the groups which may hold location tags.
+	gpsGroups = map[string]bool{"": true, "exif": true, "xmp": true, "xmp-exif": true, "quicktime": true, "keys": true, "userdata": true}
+)
+
+// originalTags returns the tags kept in published originals.
+func originalTags(c *Config) []string {
+	if c.Metadata == nil {
+		return nil
+	}
+	return c.Metadata.Originals
+}
+
+// thumbnailTags returns the tags copied into thumbnails.
+func thumbnailTags(c *Config) []string {
+	if c.Metadata == nil {
+		return nil
+	}
+	return c.Metadata.Thumbnails
+}
+
+// rewritesOriginals returns true if published originals differ from their source.
+func rewritesOriginals(c *Config) bool {
+	return c.Metadata != nil || privacyEnabled(c)
+}
+
+// metadataArgs returns exiftool arguments that remove all metadata other than keep, which is copied from src.
+func metadataArgs(src string, keep []string) []string {
+	args := []string{"-all="}
+	if len(keep) == 0 {
+		return args
+	}
+
+	args = append(args, "-tagsFromFile", src)
+	for _, k := range keep {
+		args = append(args, "-"+k)
+	}
+
+	if src == "@" {
+		// browsers rely on the orientation tag to display originals upright
+		return append(args, "-EXIF:Orientation")
+	}
+	// derived files are already upright
+	return append(args, "--Orientation", "--Rotation")
+}
+
+// keepsGPS returns true if keep may include location tags.
+func keepsGPS(keep []string) bool {
+	for _, k := range keep {
+		group, tag, ok := strings.Cut(strings.ToLower(k), ":")
+		if !ok {
+			group, tag = "", group
+		}
+		if strings.HasPrefix(group, "gps") || strings.HasPrefix(tag, "gps") || (tag == "all" && gpsGroups[group]) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAllows returns true if keep includes a tag, given its family 0 and family 1 group names.
+func policyAllows(keep []string, g0 string, g1 string, name string) bool {
+	for _, k := range keep {
+		group, tag, ok := strings.Cut(k, ":")
+		if !ok {
+			group, tag = "", k
+		}
+		if group != "" && !strings.EqualFold(group, g0) && !strings.EqualFold(group, g1) {
+			continue
+		}
+		if strings.EqualFold(tag, "all") || strings.EqualFold(tag, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataViolation is metadata in a published file that the configuration does not allow.
+type MetadataViolation struct {
+	Path   string
+	Tag    string
+	Value  string
+	Reason string
+}
+
+func (v MetadataViolation) String() string {
+	if v.Value == "" {
+		return fmt.Sprintf("%s: %s (%s)", v.Path, v.Tag, v.Reason)
+	}
+	return fmt.Sprintf("%s: %s=%q (%s)", v.Path, v.Tag, v.Value, v.Reason)
+}
+
+// AuditMetadata checks the published files in c.OutDir against the metadata policy and
+// location privacy settings, returning any violations found.
+func AuditMetadata(c *Config) ([]MetadataViolation, error) {
+	if err := validatePrivacy(c); err != nil {
+		return nil, err
+	}
+	if c.Metadata == nil && !privacyEnabled(c) {
+		klog.Warningf("no metadata policy or location privacy settings are configured; nothing to audit")
+		return nil, nil
+	}
+
+	paths := []string{}
+	err := godirwalk.Walk(c.OutDir, &godirwalk.Options{
+		Callback: func(path string, de *godirwalk.Dirent) error {
+			if filepath.Base(path)[0] == '.' {
+				return godirwalk.SkipThis
+			}
+			if !de.IsDir() && formatFor(path) != nil {
+				paths = append(paths, path)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	et, err := exiftool.NewExiftool(exiftool.CoordFormant("%+.6f"), exiftool.PrintGroupNames("0:1"))
+	if err != nil {
+		return nil, fmt.Errorf("exiftool: %w", err)
+	}
+	defer func() {
+		if err := et.Close(); err != nil {
+			klog.Errorf("Failed to close exiftool: %v", err)
+		}
+	}()
+
+	klog.Infof("auditing metadata in %d files ...", len(paths))
+	vs := []MetadataViolation{}
+	for _, p := range paths {
+		fi := et.ExtractMetadata(p)[0]
+		if fi.Err != nil {
+			return nil, fmt.Errorf("extract %s: %w", p, fi.Err)
+		}
+		vs = append(vs, auditFile(p, fi, c)...)
+	}
+	return vs, nil
+}
+
+// auditFile checks the metadata of a single published file, extracted with family 0 and 1 group names.
+func auditFile(path string, fi exiftool.FileMetadata, c *Config) []MetadataViolation {
+	// thumbnails are written to a "_" directory alongside the original
+	keep := originalTags(c)
+	if filepath.Base(filepath.Dir(path)) == "_" {
+		keep = thumbnailTags(c)
+	}
+
+	vs := []MetadataViolation{}
+	plain := exiftool.FileMetadata{Fields: map[string]interface{}{}}
+	hasGPS := false
+
+	keys := []string{}
+	for k := range fi.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		parts := strings.Split(k, ":")
+		name := parts[len(parts)-1]
+		plain.Fields[name] = fi.Fields[k]
+		if len(parts) == 1 {
+			continue
+		}
+
+		g0 := parts[0]
+		g1 := parts[0]
+		if len(parts) > 2 {
+			g1 = parts[1]
+		}
+
+		if intrinsicGroups[strings.ToLower(g0)] && !metadataGroups[strings.ToLower(g1)] {
+			continue
+		}
+		if mandatoryTags[strings.ToLower(name)] {
+			continue
+		}
+
+		isGPS := strings.EqualFold(g1, "GPS") || strings.HasPrefix(strings.ToLower(name), "gps")
+		if isGPS {
+			hasGPS = true
+		}
+		// locations are checked against the privacy settings below
+		if isGPS && privacyEnabled(c) {
+			continue
+		}
+
+		if c.Metadata != nil && !policyAllows(keep, g0, g1, name) {
+			vs = append(vs, MetadataViolation{Path: path, Tag: k, Value: fmt.Sprint(fi.Fields[k]), Reason: "not in metadata policy"})
+		}
+	}
+
+	if hasGPS && privacyEnabled(c) {
+		vs = append(vs, auditGPS(path, plain, c)...)
+	}
+	return vs
+}
+
+// auditGPS checks the location of a published file against the privacy settings.
+func auditGPS(path string, fi exiftool.FileMetadata, c *Config) []MetadataViolation {
+	gps := readGPS(fi)
+	if gps == nil {
+		return nil
+	}
+
+	pos := fmt.Sprintf("%f, %f", gps.Latitude, gps.Longitude)
+	if c.GPSPolicy == GPSStrip {
+		return []MetadataViolation{{Path: path, Tag: "GPSPosition", Value: pos, Reason: "GPS policy is strip"}}
+	}
+
+	for _, z := range c.PrivacyZones {
+		if z.Contains(gps) {
+			return []MetadataViolation{{Path: path, Tag: "GPSPosition", Value: pos, Reason: fmt.Sprintf("within privacy zone %q", z.Name)}}
+		}
+	}
+
+	if c.GPSPolicy == GPSRound {
+		precision := gpsPrecision(c)
+		// allow for the limited precision of the rational numbers EXIF stores coordinates as
+		tolerance := math.Pow(10, -float64(precision)) / 100
+		if math.Abs(gps.Latitude-roundTo(gps.Latitude, precision)) > tolerance ||
+			math.Abs(gps.Longitude-roundTo(gps.Longitude, precision)) > tolerance {
+			return []MetadataViolation{{Path: path, Tag: "GPSPosition", Value: pos, Reason: fmt.Sprintf("not rounded to %d decimal places", precision)}}
+		}
+	}
+	return nil
+}
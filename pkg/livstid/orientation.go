@@ -0,0 +1,80 @@
+package livstid
+
+import (
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/anthonynsimon/bild/clone"
+)
+
+// orientations maps exiftool's printed EXIF Orientation values to their numeric equivalent.
+var orientations = map[string]int{
+	"horizontal (normal)":                 1,
+	"mirror horizontal":                   2,
+	"rotate 180":                          3,
+	"mirror vertical":                     4,
+	"mirror horizontal and rotate 270 cw": 5,
+	"rotate 90 cw":                        6,
+	"mirror horizontal and rotate 90 cw":  7,
+	"rotate 270 cw":                       8,
+}
+
+// parseOrientation converts an EXIF Orientation value (numeric or printed) into 1-8, defaulting to 1.
+func parseOrientation(s string) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if o, ok := orientations[s]; ok {
+		return o
+	}
+	if o, err := strconv.Atoi(s); err == nil && o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// swapsAxes returns true if the orientation transposes width and height.
+func swapsAxes(o int) bool {
+	return o >= 5 && o <= 8
+}
+
+// applyOrientation rotates and flips decoded pixels so that they display as intended by the EXIF Orientation tag.
+func applyOrientation(img image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return img
+	}
+
+	src := clone.AsShallowRGBA(img)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if swapsAxes(o) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch o {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 270 CW
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,75 @@
+package livstid
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestParseOrientation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{in: "Horizontal (normal)", want: 1},
+		{in: "Rotate 90 CW", want: 6},
+		{in: " rotate 270 cw ", want: 8},
+		{in: "Mirror horizontal and rotate 90 CW", want: 7},
+		{in: "3", want: 3},
+		{in: "9", want: 1},
+		{in: "", want: 1},
+		{in: "sideways", want: 1},
+	}
+	for _, tc := range tests {
+		if got := parseOrientation(tc.in); got != tc.want {
+			t.Errorf("parseOrientation(%q) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// a 3x2 image whose pixels are numbered row by row:
+	//
+	//	0 1 2
+	//	3 4 5
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := range 2 {
+		for x := range 3 {
+			src.Set(x, y, color.RGBA{R: uint8(y*3 + x), A: 255})
+		}
+	}
+
+	tests := []struct {
+		o    int
+		want [][]uint8
+	}{
+		{o: 1, want: [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{o: 2, want: [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{o: 3, want: [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{o: 4, want: [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{o: 5, want: [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		{o: 6, want: [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{o: 7, want: [][]uint8{{5, 2}, {4, 1}, {3, 0}}},
+		{o: 8, want: [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+	}
+	for _, tc := range tests {
+		img := applyOrientation(src, tc.o)
+		b := img.Bounds()
+		got := [][]uint8{}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := []uint8{}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				row = append(row, uint8(r>>8))
+			}
+			got = append(got, row)
+		}
+		if !slices.EqualFunc(got, tc.want, slices.Equal) {
+			t.Errorf("applyOrientation(%d) = %v, want %v", tc.o, got, tc.want)
+		}
+		if swapsAxes(tc.o) != (b.Dx() == 2) {
+			t.Errorf("swapsAxes(%d) = %v, but the image is %dx%d", tc.o, swapsAxes(tc.o), b.Dx(), b.Dy())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package livstid
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"k8s.io/klog/v2"
+)
+
+// GPS policies for published images.
+const (
+	GPSKeep  = "keep"
+	GPSRound = "round"
+	GPSStrip = "strip"
+)
+
+// defaultGPSPrecision is the number of decimal places kept when rounding, roughly 1km.
+var defaultGPSPrecision = 2
+
+// PrivacyZone is a circular area in which photo locations are never published.
+type PrivacyZone struct {
+	Name      string  `yaml:"name"`
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	// Radius is in meters.
+	Radius float64 `yaml:"radius"`
+}
+
+// Contains returns true if c is within the zone.
+func (z PrivacyZone) Contains(c *Coordinates) bool {
+	if c == nil {
+		return false
+	}
+	return c.Distance(&Coordinates{Latitude: z.Latitude, Longitude: z.Longitude}) <= z.Radius
+}
+
+// privacyEnabled returns true if published files may need their locations rewritten.
+func privacyEnabled(c *Config) bool {
+	return len(c.PrivacyZones) > 0 || (c.GPSPolicy != "" && c.GPSPolicy != GPSKeep)
+}
+
+// validatePrivacy checks the privacy configuration.
+func validatePrivacy(c *Config) error {
+	switch c.GPSPolicy {
+	case "", GPSKeep, GPSRound, GPSStrip:
+	default:
+		return fmt.Errorf("unknown GPS policy %q (want %s, %s or %s)", c.GPSPolicy, GPSKeep, GPSRound, GPSStrip)
+	}
+
+	if c.GPSPrecision != nil && *c.GPSPrecision < 0 {
+		return fmt.Errorf("GPS precision must not be negative: %d", *c.GPSPrecision)
+	}
+
+	for _, z := range c.PrivacyZones {
+		if z.Radius <= 0 {
+			return fmt.Errorf("privacy zone %q has no radius", z.Name)
+		}
+	}
+	return nil
+}
+
+// gpsPrecision returns the number of decimal places kept by the "round" GPS policy.
+func gpsPrecision(c *Config) int {
+	if c.GPSPrecision == nil {
+		return defaultGPSPrecision
+	}
+	return *c.GPSPrecision
+}
+
+// applyPrivacy sanitizes image locations in place, after they are geocoded and before anything is
+// published: images inside a privacy zone lose their location and place entirely, and the GPS policy
+// is applied to the rest.
+func applyPrivacy(is []*Image, c *Config) error {
+	if err := validatePrivacy(c); err != nil {
+		return err
+	}
+
+	precision := gpsPrecision(c)
+
+	zoned := 0
+	for _, i := range is {
+		if i.GPS == nil {
+			continue
+		}
+
+		for _, z := range c.PrivacyZones {
+			if z.Contains(i.GPS) {
+				klog.V(1).Infof("%s is within privacy zone %q", i.InPath, z.Name)
+				i.GPS = nil
+				i.Place = nil
+				zoned++
+				break
+			}
+		}
+
+		if i.GPS == nil {
+			continue
+		}
+
+		switch c.GPSPolicy {
+		case GPSStrip:
+			i.GPS = nil
+		case GPSRound:
+			i.GPS = &Coordinates{
+				Latitude:  roundTo(i.GPS.Latitude, precision),
+				Longitude: roundTo(i.GPS.Longitude, precision),
+				Altitude:  math.Round(i.GPS.Altitude),
+			}
+		}
+	}
+
+	if zoned > 0 {
+		klog.Infof("removed locations from %d images within privacy zones", zoned)
+	}
+	return nil
+}
+
+// trackPrivacy returns tracks as they may be published: without points inside privacy zones,
+// which split a track in two, and with the GPS policy applied.
+func trackPrivacy(ts []*Track, c *Config) []*Track {
+	if c.GPSPolicy == GPSStrip {
+		return nil
+	}
+
+	precision := gpsPrecision(c)
+
+	out := []*Track{}
+	for _, t := range ts {
+		cur := &Track{Name: t.Name}
+		for _, p := range t.Points {
+			private := false
+			for _, z := range c.PrivacyZones {
+				if z.Contains(&p.Coordinates) {
+					private = true
+					break
+				}
+			}
+			if private {
+				out = append(out, cur)
+				cur = &Track{Name: t.Name}
+				continue
+			}
+
+			if c.GPSPolicy == GPSRound {
+				p.Coordinates = Coordinates{
+					Latitude:  roundTo(p.Latitude, precision),
+					Longitude: roundTo(p.Longitude, precision),
+					Altitude:  math.Round(p.Altitude),
+				}
+				if n := len(cur.Points); n > 0 && cur.Points[n-1].Latitude == p.Latitude && cur.Points[n-1].Longitude == p.Longitude {
+					continue
+				}
+			}
+			cur.Points = append(cur.Points, p)
+		}
+		out = append(out, cur)
+	}
+
+	kept := []*Track{}
+	for _, t := range out {
+		if len(t.Points) > 1 {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func roundTo(f float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(f*p) / p
+}
+
+// gpsArgs returns exiftool arguments that replace any location in a published file with c,
+// or remove it entirely if c is nil.
+func gpsArgs(c *Coordinates) []string {
+	args := []string{"-gps:all=", "-xmp:gps*="}
+	if c == nil {
+		return append(args, "-GPSCoordinates=")
+	}
+
+	// exiftool derives the reference direction from the sign
+	lat := strconv.FormatFloat(c.Latitude, 'f', -1, 64)
+	lon := strconv.FormatFloat(c.Longitude, 'f', -1, 64)
+	return append(args,
+		"-GPSLatitude="+lat, "-GPSLatitudeRef="+lat,
+		"-GPSLongitude="+lon, "-GPSLongitudeRef="+lon,
+		"-GPSCoordinates="+lat+", "+lon,
+	)
+}
@@ -0,0 +1,238 @@
+package livstid
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anthonynsimon/bild/imgio"
+	"github.com/otiai10/copy"
+	"k8s.io/klog/v2"
+)
+
+// ExiftoolTimeout is the maximum amount of time to wait for a single exiftool invocation.
+var ExiftoolTimeout = time.Minute
+
+// exiftoolPath returns the path to exiftool.
+func exiftoolPath() (string, error) {
+	path, err := exec.LookPath("exiftool")
+	if err != nil {
+		return "", errors.New("exiftool not installed in $PATH")
+	}
+	return path, nil
+}
+
+// runExiftool runs a one-off exiftool command.
+func runExiftool(args ...string) error {
+	bin, err := exiftoolPath()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExiftoolTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	klog.V(1).Infof("running %s", cmd)
+	if bs, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", cmd, err, bs)
+	}
+	return nil
+}
+
+// legacyPolicyName is the file within the output directory which recorded the publish policy in
+// earlier versions, where it would be synced along with the site.
+var legacyPolicyName = ".publish-policy"
+
+// policyPath returns the file within the cache directory which records the policy the output
+// directory was published with, or "" if caching is disabled.
+func policyPath(c *Config, dir string) string {
+	if dir == "" {
+		return ""
+	}
+	// the cache directory may be shared by several output directories
+	out, err := filepath.Abs(c.OutDir)
+	if err != nil {
+		klog.Warningf("publish policy will not be recorded: %v", err)
+		return ""
+	}
+	sum := sha256.Sum256([]byte(out))
+	return filepath.Join(dir, "publish-policy-"+hex.EncodeToString(sum[:8]))
+}
+
+// publishPolicy returns a fingerprint of the settings which change the contents of published files,
+// or "" if files are published unchanged.
+func publishPolicy(c *Config) string {
+	if !rewritesOriginals(c) {
+		return ""
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "gps %q %d\n", c.GPSPolicy, gpsPrecision(c))
+	for _, z := range c.PrivacyZones {
+		fmt.Fprintf(h, "zone %+v\n", z)
+	}
+	if c.Metadata != nil {
+		fmt.Fprintf(h, "metadata %q %q\n", c.Metadata.Originals, c.Metadata.Thumbnails)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// policyChanged returns true if the output directory was published with a different policy, so
+// that every file must be published again. Without a cache directory to record the policy in,
+// files which rewrite metadata are always published again.
+func policyChanged(c *Config, cache string) (bool, error) {
+	var bs []byte
+	if path := policyPath(c, cache); path != "" {
+		var err error
+		bs, err = os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("read: %w", err)
+		}
+	}
+
+	if strings.TrimSpace(string(bs)) == publishPolicy(c) {
+		return false, nil
+	}
+	klog.Infof("publish policy changed, republishing all files")
+	return true, nil
+}
+
+// savePolicy records the policy that the output directory was published with, and removes the
+// record from the output directory left by earlier versions.
+func savePolicy(c *Config, cache string) error {
+	if err := os.Remove(filepath.Join(c.OutDir, legacyPolicyName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove: %w", err)
+	}
+
+	path := policyPath(c, cache)
+	if path == "" {
+		return nil
+	}
+	p := publishPolicy(c)
+	if p == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(p+"\n"), 0o644); err != nil { //nolint:gosec // file permissions are standard
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// needsUpdate returns true if the published copy at dest is missing or older than src.
+// If compareSize is set, a size mismatch also triggers an update.
+func needsUpdate(src string, dest string, compareSize bool) (bool, error) {
+	sst, err := os.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("stat: %w", err)
+	}
+
+	dst, err := os.Stat(dest)
+	if err != nil {
+		klog.V(1).Infof("updating %s: does not exist", dest)
+		return true, nil
+	}
+
+	if compareSize && sst.Size() != dst.Size() {
+		klog.Infof("updating %s: size mismatch (%d to %d)", dest, sst.Size(), dst.Size())
+		return true, nil
+	}
+
+	if sst.ModTime().After(dst.ModTime()) {
+		klog.Infof("updating %s: source newer", dest)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// publish writes the web-viewable version of an image to dest: either a verbatim copy
+// of the original, a transcoded video, or a full-size JPEG derivative for formats
+// browsers can't display. If the image had to be decoded, the decoded pixels are returned for reuse.
+func publish(i *Image, dest string, c *Config) (image.Image, error) {
+	f := formatFor(i.InPath)
+	if f != nil && f.Video && c.TranscodeVideos {
+		if err := transcode(i.InPath, dest); err != nil {
+			return nil, fmt.Errorf("transcode: %w", err)
+		}
+		return nil, sanitize(i, c, i.InPath, originalTags(c), dest)
+	}
+
+	if f != nil && f.WebSafe {
+		if err := copy.Copy(i.InPath, dest); err != nil {
+			return nil, fmt.Errorf("copy: %w", err)
+		}
+		return nil, sanitize(i, c, "@", originalTags(c), dest)
+	}
+
+	klog.Infof("creating web derivative of %s at %s", i.InPath, dest)
+	img, err := decodeSource(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	if err := imgio.Save(dest, img, imgio.JPEGEncoder(derivativeQuality)); err != nil {
+		return nil, fmt.Errorf("save: %w", err)
+	}
+	return img, sanitize(i, c, i.InPath, originalTags(c), dest)
+}
+
+// sanitize applies the metadata policy and location privacy settings to published files.
+// Tags listed in keep are copied from src, which is "@" for verbatim copies, or the original
+// for derived files. Derived files are encoded without metadata, so they are left alone if no
+// metadata policy is set.
+func sanitize(i *Image, c *Config, src string, keep []string, dests ...string) error {
+	verbatim := src == "@"
+	args := []string{}
+
+	switch {
+	case verbatim && c.Metadata != nil:
+		args = append(args, metadataArgs(src, keep)...)
+	case c.Metadata != nil && len(keep) > 0:
+		args = append(args, metadataArgs(src, keep)...)
+	case !verbatim:
+		return nil
+	}
+
+	// a metadata policy which doesn't keep locations has already removed them
+	if privacyEnabled(c) && (c.Metadata == nil || keepsGPS(keep)) {
+		args = append(args, gpsArgs(i.GPS)...)
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	klog.V(1).Infof("sanitizing metadata in %s", dests)
+	args = append(args, "-overwrite_original")
+	args = append(args, dests...)
+	if err := runExiftool(args...); err != nil {
+		// never leave an unsanitized copy behind
+		for _, d := range dests {
+			if rerr := os.Remove(d); rerr != nil {
+				klog.Errorf("unable to remove %s: %v", d, rerr)
+			}
+		}
+		return fmt.Errorf("sanitize: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package livstid
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyChanged(t *testing.T) {
+	precision := 1
+
+	tests := []struct {
+		name  string
+		saved *Config
+		now   *Config
+		want  bool
+	}{
+		{name: "never published unchanged", now: &Config{}, want: false},
+		{name: "never published with a policy", now: &Config{GPSPolicy: GPSRound}, want: true},
+		{name: "same policy", saved: &Config{GPSPolicy: GPSRound}, now: &Config{GPSPolicy: GPSRound}, want: false},
+		{name: "new precision", saved: &Config{GPSPolicy: GPSRound}, now: &Config{GPSPolicy: GPSRound, GPSPrecision: &precision}, want: true},
+		{name: "policy removed", saved: &Config{GPSPolicy: GPSRound}, now: &Config{}, want: true},
+		{name: "policy removed and saved", saved: &Config{}, now: &Config{}, want: false},
+	}
+	for _, tc := range tests {
+		out, cache := t.TempDir(), t.TempDir()
+		if tc.saved != nil {
+			tc.saved.OutDir = out
+			if err := savePolicy(tc.saved, cache); err != nil {
+				t.Fatalf("%s: savePolicy: %v", tc.name, err)
+			}
+		}
+
+		tc.now.OutDir = out
+		got, err := policyChanged(tc.now, cache)
+		if err != nil {
+			t.Fatalf("%s: policyChanged: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: policyChanged = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyOutsideOutput(t *testing.T) {
+	c := &Config{OutDir: t.TempDir(), GPSPolicy: GPSRound}
+	cache := t.TempDir()
+
+	// a record left in the output directory by earlier versions is neither trusted nor kept
+	legacy := filepath.Join(c.OutDir, legacyPolicyName)
+	if err := os.WriteFile(legacy, []byte(publishPolicy(c)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := policyChanged(c, cache); err != nil || !changed {
+		t.Errorf("policyChanged with a legacy record = %v, %v; want true", changed, err)
+	}
+
+	if err := savePolicy(c, cache); err != nil {
+		t.Fatalf("savePolicy: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy record still exists: %v", err)
+	}
+	if changed, err := policyChanged(c, cache); err != nil || changed {
+		t.Errorf("policyChanged after saving = %v, %v; want false", changed, err)
+	}
+
+	// output directories sharing a cache directory have their own records
+	other := &Config{OutDir: t.TempDir(), GPSPolicy: GPSRound}
+	if changed, err := policyChanged(other, cache); err != nil || !changed {
+		t.Errorf("policyChanged of another output = %v, %v; want true", changed, err)
+	}
+}
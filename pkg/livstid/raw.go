@@ -0,0 +1,62 @@
+package livstid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// rawPreviewTags are the embedded previews to try, in order of preference.
+var rawPreviewTags = []string{"JpgFromRaw", "PreviewImage", "OtherImage", "ThumbnailImage"}
+
+// isRaw returns true if the path is a camera RAW file.
+func isRaw(path string) bool {
+	f := formatFor(path)
+	return f != nil && f.Raw
+}
+
+// pairKey returns the key used to pair RAW files with their JPEG siblings: the directory and lowercase basename.
+func pairKey(path string) string {
+	base := filepath.Base(path)
+	return filepath.Join(filepath.Dir(path), strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base))))
+}
+
+// decodeRawPreview decodes the largest embedded JPEG preview of a RAW file using exiftool.
+func decodeRawPreview(path string) (image.Image, error) {
+	bin, err := exiftoolPath()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range rawPreviewTags {
+		ctx, cancel := context.WithTimeout(context.Background(), ConvertTimeout)
+		cmd := exec.CommandContext(ctx, bin, "-b", "-"+tag, path)
+		bs, err := cmd.Output()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %w", cmd, err)
+		}
+
+		if len(bs) == 0 {
+			klog.V(1).Infof("%s: no %s", path, tag)
+			continue
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(bs))
+		if err != nil {
+			klog.Warningf("%s: unable to decode %s: %v", path, tag, err)
+			continue
+		}
+		klog.V(1).Infof("%s: using embedded %s (%s)", path, tag, img.Bounds().Size())
+		return img, nil
+	}
+
+	return nil, fmt.Errorf("no embedded preview found in %s", path)
+}
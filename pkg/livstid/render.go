@@ -8,6 +8,8 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,9 +26,15 @@ var idxTmpl string
 //go:embed assets/ng2/album.tmpl
 var albumTmpl string
 
+//go:embed assets/ng2/map.tmpl
+var mapTmpl string
+
 //go:embed assets/ng2/style.css
 var styleText string
 
+// geoJSONName is the filename of GeoJSON data written alongside each map.
+var geoJSONName = "photos.geojson"
+
 var assetsDir = "pkg/livstid/assets/ng2"
 
 // Render generates HTML output for the photo assembly.
@@ -47,7 +55,25 @@ func Render(c *Config, a *Assembly) error {
 		return fmt.Errorf("write tags: %w", err)
 	}
 
-	if err := writeAlbums(c, a.HierAlbums); err != nil {
+	if err := writeAlbums(c, a.PlaceAlbums); err != nil {
+		return fmt.Errorf("write places: %w", err)
+	}
+
+	if err := writeAlbums(c, a.EventAlbums); err != nil {
+		return fmt.Errorf("write events: %w", err)
+	}
+
+	if err := writeAlbums(c, a.YearAlbums); err != nil {
+		return fmt.Errorf("write years: %w", err)
+	}
+
+	if a.OnThisDay != nil {
+		if err := writeAlbums(c, []*Album{a.OnThisDay}); err != nil {
+			return fmt.Errorf("write on this day: %w", err)
+		}
+	}
+
+	if err := writeAlbums(c, hierPages(a)); err != nil {
 		return fmt.Errorf("write hier albums: %w", err)
 	}
 
@@ -55,6 +81,10 @@ func Render(c *Config, a *Assembly) error {
 		return fmt.Errorf("write stream: %w", err)
 	}
 
+	if err := writeMaps(c, a); err != nil {
+		return fmt.Errorf("write maps: %w", err)
+	}
+
 	if err := writeIndex(c, a); err != nil {
 		return fmt.Errorf("write index: %w", err)
 	}
@@ -79,10 +109,68 @@ func copyAssets(inDir string, outDir string) error {
 	return nil
 }
 
+// albumPage describes one page of a paginated album.
+type albumPage struct {
+	Number int
+	Count  int
+	// Up is the relative path from the page to the first page of the album.
+	Up   string
+	Prev string
+	Next string
+}
+
+// pages splits the images of an album into pages of PageSize.
+func (a *Album) pages() [][]*Image {
+	if a.PageSize <= 0 || len(a.Images) <= a.PageSize {
+		return [][]*Image{a.Images}
+	}
+
+	ps := [][]*Image{}
+	for is := a.Images; len(is) > 0; {
+		n := min(a.PageSize, len(is))
+		ps = append(ps, is[:n])
+		is = is[n:]
+	}
+	return ps
+}
+
+// pageDir returns the output directory of page n of an album: the album itself for
+// the first page, and page/<n> within it for the rest.
+func pageDir(a *Album, n int) string {
+	if n == 1 {
+		return a.OutPath
+	}
+	return filepath.Join(a.OutPath, "page", strconv.Itoa(n))
+}
+
+// newAlbumPage returns the navigation for page n of count.
+func newAlbumPage(n int, count int) albumPage {
+	p := albumPage{Number: n, Count: count}
+	if n > 1 {
+		p.Up = "../../"
+	}
+
+	switch {
+	case n == 2:
+		p.Prev = "../../"
+	case n > 2:
+		p.Prev = fmt.Sprintf("../%d/", n-1)
+	}
+
+	switch {
+	case n == count:
+	case n == 1:
+		p.Next = "page/2/"
+	default:
+		p.Next = fmt.Sprintf("../%d/", n+1)
+	}
+	return p
+}
+
 func writeRecent(c *Config, a *Album) error {
 	klog.V(1).Infof("writing recent with %d images ...", len(a.Images))
 
-	bs, err := renderAlbum(c, a, streamTmpl)
+	bs, err := renderAlbum(c, a, albumPage{Number: 1, Count: 1}, streamTmpl)
 	if err != nil {
 		return fmt.Errorf("render stream: %w", err)
 	}
@@ -119,28 +207,128 @@ func writeIndex(c *Config, a *Assembly) error {
 func writeAlbums(c *Config, as []*Album) error {
 	klog.Infof("Writing out %d albums ...", len(as))
 	for _, a := range as {
-		klog.V(1).Infof("rendering album %s [%s] with %d images ...", a.Title, a.OutPath, len(a.Images))
-		bs, err := renderAlbum(c, a, albumTmpl)
-		if err != nil {
-			return fmt.Errorf("render album: %w", err)
+		pages := a.pages()
+		klog.V(1).Infof("rendering album %s [%s] with %d images across %d pages ...", a.Title, a.OutPath, len(a.Images), len(pages))
+
+		for n, is := range pages {
+			if err := writeAlbumPage(c, a, is, n+1, len(pages)); err != nil {
+				return fmt.Errorf("page %d: %w", n+1, err)
+			}
 		}
+	}
+
+	return nil
+}
+
+// writeAlbumPage writes page n of an album, containing is.
+func writeAlbumPage(c *Config, a *Album, is []*Image, n int, count int) error {
+	dir := pageDir(a, n)
+	page := *a
+	page.OutPath = dir
+	page.Images = is
+
+	bs, err := renderAlbum(c, &page, newAlbumPage(n, count), albumTmpl)
+	if err != nil {
+		return fmt.Errorf("render album: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	p := filepath.Join(dir, "index.html")
+	klog.V(1).Infof("Writing album index to %s", p)
+
+	if err := os.WriteFile(p, bs, 0o644); err != nil { //nolint:gosec // file permissions are standard
+		return fmt.Errorf("write file: %w", err)
+	}
+	return nil
+}
+
+// hierPages returns the pages of directories which only contain nested albums. These list their
+// children rather than images; directories with images of their own list their children on the album page.
+func hierPages(a *Assembly) []*Album {
+	own := map[string]bool{}
+	for _, al := range a.Albums {
+		own[al.OutPath] = true
+	}
 
-		if err := os.MkdirAll(a.OutPath, 0o755); err != nil { //nolint:gosec // directory permissions are standard
-			return fmt.Errorf("mkdir: %w", err)
+	pages := []*Album{}
+	for _, h := range a.HierAlbums {
+		if own[h.OutPath] || len(h.Children) == 0 {
+			continue
 		}
+		p := *h
+		p.Images = nil
+		pages = append(pages, &p)
+	}
+	return pages
+}
 
-		p := filepath.Join(a.OutPath, "index.html")
-		klog.V(1).Infof("Writing album index to %s", p)
+// writeMaps writes GeoJSON and a map page for every rendered album which is geotagged or has a
+// track, and for the assembly as a whole.
+func writeMaps(c *Config, a *Assembly) error {
+	as := slices.Concat(a.Albums, a.Favorites, a.TagAlbums, a.PlaceAlbums, a.EventAlbums, a.YearAlbums)
+	if a.OnThisDay != nil {
+		as = append(as, a.OnThisDay)
+	}
 
-		if err := os.WriteFile(p, bs, 0o644); err != nil { //nolint:gosec // file permissions are standard
-			return fmt.Errorf("write file: %w", err)
+	for _, al := range as {
+		if centroid(al.Images) == nil && len(al.Tracks) == 0 {
+			continue
+		}
+		dir := filepath.Join(al.OutPath, "map")
+		if err := writeMap(c, dir, al.Title, al, albumGeoJSON(al, dir)); err != nil {
+			return fmt.Errorf("album %s: %w", al.Title, err)
 		}
 	}
 
+	dir := filepath.Join(c.OutDir, "map")
+	return writeMap(c, dir, "All", nil, assemblyGeoJSON(a, dir))
+}
+
+// writeMap writes GeoJSON data and a map page rendering it into dir.
+func writeMap(c *Config, dir string, title string, a *Album, g *geoJSON) error {
+	bs, err := writeGeoJSON(filepath.Join(dir, geoJSONName), g)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("map").Funcs(tmplFunctions()).Parse(mapTmpl)
+	if err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
+
+	data := struct {
+		Album      *Album
+		Title      string
+		Collection string
+		Root       string
+		GeoJSON    template.JS
+		Style      template.CSS
+	}{
+		Album:      a,
+		Title:      title,
+		Collection: c.Collection,
+		Root:       relURL(dir, c.OutDir) + "/",
+		GeoJSON:    template.JS(bs),         //nolint:gosec // JSON is generated by json.Marshal
+		Style:      template.CSS(styleText), //nolint:gosec // CSS is from trusted source
+	}
+
+	var tpl bytes.Buffer
+	if err = tmpl.Execute(&tpl, data); err != nil {
+		return fmt.Errorf("execute: %w", err)
+	}
+
+	p := filepath.Join(dir, "index.html")
+	klog.V(1).Infof("Writing map to %s", p)
+	if err := os.WriteFile(p, tpl.Bytes(), 0o644); err != nil { //nolint:gosec // file permissions are standard
+		return fmt.Errorf("write file: %w", err)
+	}
 	return nil
 }
 
-func renderAlbum(c *Config, a *Album, templateString string) ([]byte, error) {
+func renderAlbum(c *Config, a *Album, page albumPage, templateString string) ([]byte, error) {
 	tmpl, err := template.New("album").Funcs(tmplFunctions()).Parse(templateString)
 	if err != nil {
 		return nil, fmt.Errorf("parse: %w", err)
@@ -150,11 +338,13 @@ func renderAlbum(c *Config, a *Album, templateString string) ([]byte, error) {
 		Title      string
 		Collection string
 		Album      *Album
+		Page       albumPage
 		Style      template.CSS
 	}{
 		Collection: c.Collection,
 		Title:      a.Title,
 		Album:      a,
+		Page:       page,
 		Style:      template.CSS(styleText), //nolint:gosec // CSS is from trusted source
 	}
 
@@ -181,12 +371,22 @@ func renderAlbumIndex(c *Config, a *Assembly, ts string) ([]byte, error) {
 		Style       template.CSS
 		Albums      []*Album
 		Favorites   []*Album
+		Places      []*Album
+		Events      []*Album
+		Years       []*Album
+		OnThisDay   *Album
+		Tree        *AlbumNode
 	}{
 		Collection:  c.Collection,
 		Description: c.Description,
 		OutDir:      c.OutDir,
 		Albums:      a.Albums,
 		Favorites:   a.Favorites,
+		Places:      a.PlaceAlbums,
+		Events:      a.EventAlbums,
+		Years:       a.YearAlbums,
+		OnThisDay:   a.OnThisDay,
+		Tree:        a.Tree,
 		Recent:      a.Recent,
 		Style:       template.CSS(styleText), //nolint:gosec // CSS is from trusted source
 	}
@@ -233,12 +433,9 @@ func tmplFunctions() template.FuncMap {
 			}
 			return strings.Join(relPath, "/")
 		},
-		"ImageURL": func(b string, s string) string {
-			r, err := filepath.Rel(b, s)
-			if err != nil {
-				return fmt.Sprintf("ERROR[%v]", err)
-			}
-			return filepath.Dir(r) + "/#nanogallery/i/0/" + filepath.Base(r)
+		"ImageURL": viewerURL,
+		"Geotagged": func(a *Album) bool {
+			return centroid(a.Images) != nil || len(a.Tracks) > 0
 		},
 		"Random": func(as []*Album) *Image {
 			if len(as) == 0 {
@@ -264,14 +461,68 @@ func tmplFunctions() template.FuncMap {
 		},
 		"RandInHier": func(as []*Album, top string) *Image {
 			is := []*Image{}
+			covers := []*Image{}
 			for _, a := range as {
-				if a.Hier[0] == top {
+				if a.Hier[0] == top && !a.Hidden {
 					is = append(is, a.Images...)
+					if a.Cover != nil {
+						covers = append(covers, a.Cover)
+					}
 				}
 			}
+			// albums with a chosen cover are better represented by it
+			if len(covers) > 0 {
+				is = covers
+			}
+			if len(is) == 0 {
+				return &Image{}
+			}
 			return is[rand.IntN(len(is))] //nolint:gosec // not used for security
 		},
 
+		"Duration": func(d time.Duration) string {
+			s := int(d.Round(time.Second).Seconds())
+			if s >= 3600 {
+				return fmt.Sprintf("%d:%02d:%02d", s/3600, (s/60)%60, s%60)
+			}
+			return fmt.Sprintf("%d:%02d", s/60, s%60)
+		},
+
+		// ParentTitle returns the hierarchy between the top level and the album itself, such as the source of an event.
+		"ParentTitle": func(hier []string) string {
+			if len(hier) < 3 {
+				return ""
+			}
+			return strings.Join(hier[1:len(hier)-1], " / ")
+		},
+
+		"DateRange": func(a *Album) string {
+			return dateRange(a.StartTime, a.EndTime)
+		},
+
 		"BasePath": filepath.Base,
+
+		"StackID": stackID,
+	}
+}
+
+// dateRange formats a range of dates compactly, such as "June 3–9, 2024".
+func dateRange(start time.Time, end time.Time) string {
+	if start.IsZero() {
+		return ""
+	}
+	if end.Before(start) {
+		end = start
+	}
+
+	switch {
+	case start.Year() != end.Year():
+		return start.Format("January 2, 2006") + " – " + end.Format("January 2, 2006")
+	case start.Month() != end.Month():
+		return start.Format("January 2") + " – " + end.Format("January 2, 2006")
+	case start.Day() != end.Day():
+		return fmt.Sprintf("%s–%d, %d", start.Format("January 2"), end.Day(), end.Year())
+	default:
+		return start.Format("January 2, 2006")
 	}
 }
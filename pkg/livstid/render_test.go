@@ -0,0 +1,69 @@
+package livstid
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		images   int
+		pageSize int
+		want     []int
+	}{
+		{images: 0, pageSize: 10, want: []int{0}},
+		{images: 5, pageSize: 0, want: []int{5}},
+		{images: 10, pageSize: 10, want: []int{10}},
+		{images: 11, pageSize: 10, want: []int{10, 1}},
+		{images: 25, pageSize: 10, want: []int{10, 10, 5}},
+	}
+	for _, tc := range tests {
+		a := &Album{PageSize: tc.pageSize}
+		for range tc.images {
+			a.Images = append(a.Images, &Image{})
+		}
+
+		got := []int{}
+		for _, p := range a.pages() {
+			got = append(got, len(p))
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%d images in pages of %d = %v, want %v", tc.images, tc.pageSize, got, tc.want)
+		}
+	}
+}
+
+func TestPageDir(t *testing.T) {
+	a := &Album{OutPath: filepath.Join("out", "2024", "trip")}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{n: 1, want: filepath.Join("out", "2024", "trip")},
+		{n: 2, want: filepath.Join("out", "2024", "trip", "page", "2")},
+		{n: 12, want: filepath.Join("out", "2024", "trip", "page", "12")},
+	}
+	for _, tc := range tests {
+		if got := pageDir(a, tc.n); got != tc.want {
+			t.Errorf("pageDir(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestNewAlbumPage(t *testing.T) {
+	tests := []struct {
+		n, count int
+		want     albumPage
+	}{
+		{n: 1, count: 1, want: albumPage{Number: 1, Count: 1}},
+		{n: 1, count: 3, want: albumPage{Number: 1, Count: 3, Next: "page/2/"}},
+		{n: 2, count: 3, want: albumPage{Number: 2, Count: 3, Up: "../../", Prev: "../../", Next: "../3/"}},
+		{n: 3, count: 3, want: albumPage{Number: 3, Count: 3, Up: "../../", Prev: "../2/"}},
+	}
+	for _, tc := range tests {
+		if got := newAlbumPage(tc.n, tc.count); got != tc.want {
+			t.Errorf("newAlbumPage(%d, %d) = %+v, want %+v", tc.n, tc.count, got, tc.want)
+		}
+	}
+}
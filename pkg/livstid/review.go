@@ -0,0 +1,111 @@
+package livstid
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var defaultYearReviewSize = 60
+
+// createOnThisDayAlbum returns an album of photos taken on the same month and day as now in
+// previous years, newest first, or nil if there are none. As the site is static, the album is
+// only as current as the last build.
+func createOnThisDayAlbum(is []*Image, outDir string, now time.Time) *Album {
+	found := []*Image{}
+	for _, i := range is {
+		if i.Taken.IsZero() || i.Taken.Year() >= now.Year() {
+			continue
+		}
+		if i.Taken.Month() == now.Month() && i.Taken.Day() == now.Day() {
+			found = append(found, i)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	sortImages(found, SortTakenDesc)
+	return &Album{
+		OutPath:     filepath.Join(outDir, "on-this-day"),
+		RelPath:     "on-this-day",
+		Title:       "On this day",
+		Description: now.Format("January 2") + " in previous years",
+		Hier:        []string{"on this day"},
+		Images:      found,
+		ModTime:     now,
+	}
+}
+
+// createYearAlbums returns a "year in review" album for each year with at least minSize photos,
+// newest first. Favorites and highlights are preferred, and the rest of the album is sampled
+// evenly across the year.
+func createYearAlbums(is []*Image, c *Config, minSize int) []*Album {
+	size := orDefault(c.YearReviewSize, defaultYearReviewSize)
+	years := map[int][]*Image{}
+	for _, i := range is {
+		if !i.Taken.IsZero() {
+			years[i.Taken.Year()] = append(years[i.Taken.Year()], i)
+		}
+	}
+
+	as := []*Album{}
+	for y, yis := range years {
+		if len(yis) < minSize {
+			continue
+		}
+
+		sortImages(yis, SortTaken)
+		picks := []*Image{}
+		rest := []*Image{}
+		for _, i := range yis {
+			if picked(i) {
+				picks = append(picks, i)
+			} else {
+				rest = append(rest, i)
+			}
+		}
+
+		if len(picks) > size {
+			picks = sampleEvenly(picks, size)
+		}
+		picks = append(picks, sampleEvenly(rest, size-len(picks))...)
+		sortImages(picks, SortTaken)
+
+		year := strconv.Itoa(y)
+		as = append(as, &Album{
+			OutPath:   filepath.Join(c.OutDir, "years", year),
+			RelPath:   filepath.Join("years", year),
+			Title:     fmt.Sprintf("%d in review", y),
+			Hier:      []string{"years", year},
+			Images:    picks,
+			StartTime: yis[0].Taken,
+			EndTime:   yis[len(yis)-1].Taken,
+		})
+	}
+
+	sort.Slice(as, func(i, j int) bool {
+		return as[i].RelPath > as[j].RelPath
+	})
+	return as
+}
+
+// sampleEvenly returns up to n images spread evenly across is.
+func sampleEvenly(is []*Image, n int) []*Image {
+	if n <= 0 {
+		return nil
+	}
+	if len(is) <= n {
+		return is
+	}
+
+	sampled := make([]*Image, 0, n)
+	step := float64(len(is)) / float64(n)
+	for k := range n {
+		sampled = append(sampled, is[int(float64(k)*step)])
+	}
+	return sampled
+}
@@ -0,0 +1,140 @@
+package livstid
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// Root modes, which control how input roots are combined.
+const (
+	// RootsMerge combines directories of the same name across roots into a single album.
+	RootsMerge = "merge"
+	// RootsSeparate places the albums of each root under a prefix of its own.
+	RootsSeparate = "separate"
+)
+
+// Root is an input directory.
+type Root struct {
+	Path string `yaml:"path"`
+	// Prefix is a directory which albums within the root are placed under. With separate roots,
+	// it defaults to the base name of Path.
+	Prefix string `yaml:"prefix"`
+	// Label is the title of the prefix directory in navigation. Defaults to Prefix.
+	Label string `yaml:"label"`
+	// topAlbum places photos at the top of the root in an album named after it.
+	topAlbum bool
+}
+
+// InputRoots returns the input directories from both InDirs and Roots, with defaults applied.
+func (c *Config) InputRoots() ([]Root, error) {
+	rs := []Root{}
+	for _, d := range c.InDirs {
+		rs = append(rs, Root{Path: d})
+	}
+	rs = append(rs, c.Roots...)
+	for n := range rs {
+		rs[n].topAlbum = c.TopLevelAlbum
+	}
+
+	switch c.RootMode {
+	case "", RootsMerge:
+		return rs, nil
+	case RootsSeparate:
+	default:
+		return nil, fmt.Errorf("unknown root mode %q (want %s or %s)", c.RootMode, RootsMerge, RootsSeparate)
+	}
+
+	prefixes := map[string]string{}
+	for n, r := range rs {
+		if r.Prefix == "" {
+			abs, err := filepath.Abs(r.Path)
+			if err != nil {
+				return nil, fmt.Errorf("abs: %w", err)
+			}
+			rs[n].Prefix = filepath.Base(abs)
+		}
+
+		p := rs[n].Prefix
+		if prev, ok := prefixes[p]; ok {
+			return nil, fmt.Errorf("roots %s and %s share the prefix %q: set a prefix for one of them", prev, r.Path, p)
+		}
+		prefixes[p] = r.Path
+	}
+	return rs, nil
+}
+
+// rootLabels returns the navigation labels of root prefixes, keyed by prefix.
+func rootLabels(rs []Root) map[string]string {
+	labels := map[string]string{}
+	for _, r := range rs {
+		if r.Prefix != "" && r.Label != "" {
+			labels[filepath.ToSlash(r.Prefix)] = r.Label
+		}
+	}
+	return labels
+}
+
+// removeCollisions drops images which would be published to the same path as an earlier image,
+// such as the same relative path within two merged roots, returning an error describing each.
+func removeCollisions(is []*Image, c *Config) ([]*Image, []error) {
+	seen := map[string]*Image{}
+	kept := []*Image{}
+	errs := []error{}
+
+	for _, i := range is {
+		p := publishRelPath(i, c)
+		if prev, ok := seen[p]; ok {
+			errs = append(errs, fmt.Errorf("output path collision: %s and %s both publish to %s; skipping %s", prev.InPath, i.InPath, p, i.InPath))
+			continue
+		}
+		seen[p] = i
+		kept = append(kept, i)
+	}
+
+	return kept, errs
+}
+
+// albumCollisions returns an error for each pair of distinct directories whose albums would be
+// published to the same path, such as "2024/Trip" and "2024/trip".
+func albumCollisions(albums map[string]*Album) []error {
+	keys := []string{}
+	for k := range albums {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := map[string]*Album{}
+	errs := []error{}
+	for _, k := range keys {
+		a := albums[k]
+		if prev, ok := seen[a.OutPath]; ok {
+			errs = append(errs, fmt.Errorf("output path collision: albums %s and %s both publish to %s", prev.InPath, a.InPath, a.OutPath))
+			continue
+		}
+		seen[a.OutPath] = a
+	}
+	return errs
+}
+
+// logMergedAlbums reports albums whose images come from more than one root.
+func logMergedAlbums(albums map[string]*Album) {
+	for _, a := range albums {
+		dirs := map[string]bool{}
+		for _, i := range a.Images {
+			dirs[filepath.Dir(i.InPath)] = true
+		}
+		if len(dirs) < 2 {
+			continue
+		}
+
+		ds := []string{}
+		for d := range dirs {
+			ds = append(ds, d)
+		}
+		klog.Infof("album %q merges %s", a.RelPath, strings.Join(ds, ", "))
+	}
+}
@@ -0,0 +1,173 @@
+package livstid
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/barasher/go-exiftool"
+	"k8s.io/klog/v2"
+)
+
+// emptyXMP is a minimal XMP packet, which exiftool can add tags to.
+var emptyXMP = `<?xpacket begin='' id='W5M0MpCehiHzreSzNTczkc9d'?>
+<x:xmpmeta xmlns:x='adobe:ns:meta/'>
+<rdf:RDF xmlns:rdf='http://www.w3.org/1999/02/22-rdf-syntax-ns#'>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end='w'?>
+`
+
+// xmpSidecars returns the possible XMP sidecars of an image, in increasing order of precedence:
+// photo.xmp (as written by Lightroom), then that of a paired RAW file, then photo.jpg.xmp
+// (as written by darktable).
+func xmpSidecars(i *Image) []string {
+	ps := []string{strings.TrimSuffix(i.InPath, filepath.Ext(i.InPath)) + ".xmp"}
+	if i.RawPath != "" {
+		ps = append(ps, i.RawPath+".xmp")
+	}
+	return append(ps, i.InPath+".xmp")
+}
+
+// SidecarPath returns the XMP sidecar to write metadata for a file to: an existing photo.jpg.xmp
+// or photo.xmp, or else photo.jpg.xmp.
+func SidecarPath(path string) string {
+	for _, p := range []string{path + ".xmp", strings.TrimSuffix(path, filepath.Ext(path)) + ".xmp"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return path + ".xmp"
+}
+
+// applyXMPSidecars merges metadata from any XMP sidecars of an image over its embedded metadata.
+func applyXMPSidecars(i *Image, et *lazyExiftool, mc *MetaCache) error {
+	for _, p := range xmpSidecars(i) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+
+		x, ok := mc.Get(p, fi)
+		if !ok {
+			e, err := et.Get()
+			if err != nil {
+				return err
+			}
+			x, err = readXMP(p, e)
+			if err != nil {
+				return err
+			}
+			mc.Put(p, fi, x)
+		}
+
+		klog.V(1).Infof("%s: merging XMP sidecar %s", i.BasePath, p)
+		mergeXMP(i, x)
+	}
+	return nil
+}
+
+// readXMP extracts the metadata which livstid uses from an XMP sidecar.
+func readXMP(path string, et *exiftool.Exiftool) (*Image, error) {
+	fi := et.ExtractMetadata(path)[0]
+	if fi.Err != nil {
+		return nil, fmt.Errorf("extract fail for %q: %w", path, fi.Err)
+	}
+
+	x := &Image{GPS: readGPS(fi)}
+	x.Title, _ = fi.GetString("Title")
+	if x.Title == "" {
+		x.Title, _ = fi.GetString("Headline")
+	}
+	x.Description, _ = fi.GetString("Description")
+	x.Keywords, _ = fi.GetStrings("Subject")
+	if r, err := fi.GetInt("Rating"); err == nil {
+		x.Rating, x.HasRating = int(r), true
+	}
+	x.Label, _ = fi.GetString("Label")
+	x.People, _ = fi.GetStrings("RegionName")
+	return x, nil
+}
+
+// mergeXMP overrides the metadata of an image with that set in a sidecar.
+func mergeXMP(i *Image, x *Image) {
+	if x.Title != "" {
+		i.Title = x.Title
+	}
+	if x.Description != "" {
+		i.Description = x.Description
+	}
+	if len(x.Keywords) > 0 {
+		i.Keywords = x.Keywords
+	}
+	if x.HasRating {
+		i.Rating, i.HasRating = x.Rating, true
+	}
+	if x.Label != "" {
+		i.Label = x.Label
+	}
+	if x.GPS != nil {
+		i.GPS = x.GPS
+	}
+	if len(x.People) > 0 {
+		i.People = x.People
+	}
+}
+
+// ensureSidecar returns the XMP sidecar to write metadata for a file to, creating it if necessary.
+func ensureSidecar(path string) (string, error) {
+	sp := SidecarPath(path)
+	if _, err := os.Stat(sp); !errors.Is(err, os.ErrNotExist) {
+		return sp, nil
+	}
+	//nolint:gosec // file permissions are standard
+	if err := os.WriteFile(sp, []byte(emptyXMP), 0o644); err != nil {
+		return "", fmt.Errorf("write file: %w", err)
+	}
+	return sp, nil
+}
+
+// UpdateKeywords replaces the keywords of a file with the result of fn. If sidecar is set, they are
+// written to its XMP sidecar, which is created if necessary, rather than to the file itself.
+func UpdateKeywords(et *exiftool.Exiftool, path string, sidecar bool, fn func([]string) []string) error {
+	if !sidecar {
+		fm := et.ExtractMetadata(path)
+		if fm[0].Err != nil {
+			return fmt.Errorf("extract fail for %q: %w", path, fm[0].Err)
+		}
+		ks, _ := fm[0].GetStrings("Keywords")
+		fm[0].SetStrings("Keywords", fn(ks))
+		et.WriteMetadata(fm)
+		if fm[0].Err != nil {
+			return fmt.Errorf("write %s: %w", path, fm[0].Err)
+		}
+		return nil
+	}
+
+	sp, err := ensureSidecar(path)
+	if err != nil {
+		return err
+	}
+
+	fm := et.ExtractMetadata(sp)
+	if fm[0].Err != nil {
+		return fmt.Errorf("extract fail for %q: %w", sp, fm[0].Err)
+	}
+	ks, _ := fm[0].GetStrings("Subject")
+	// sidecar keywords replace embedded ones, so a sidecar without any starts with those of the file
+	if len(ks) == 0 {
+		em := et.ExtractMetadata(path)
+		if em[0].Err == nil {
+			ks, _ = em[0].GetStrings("Keywords")
+		}
+	}
+
+	fm[0].SetStrings("Subject", fn(ks))
+	et.WriteMetadata(fm)
+	if fm[0].Err != nil {
+		return fmt.Errorf("write %s: %w", sp, fm[0].Err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package livstid
+
+import (
+	"image"
+	"math/bits"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/anthonynsimon/bild/transform"
+	"k8s.io/klog/v2"
+)
+
+var (
+	defaultStackThreshold = 12
+
+	// sharpnessWidth is the width images are reduced to before measuring sharpness.
+	sharpnessWidth = 256
+)
+
+// stackBursts groups consecutive, visually similar frames from the same camera, taken within
+// c.StackWindow of each other, under a single representative image. The album is left with
+// representatives and unstacked images, and the remaining frames are reachable via Frames.
+func stackBursts(a *Album, c *Config) {
+	if c.StackWindow <= 0 || len(a.Images) < 2 {
+		return
+	}
+	threshold := orDefault(c.StackThreshold, defaultStackThreshold)
+
+	byTime := slices.Clone(a.Images)
+	sort.SliceStable(byTime, func(i, j int) bool {
+		return byTime[i].Taken.Before(byTime[j].Taken)
+	})
+
+	bursts := [][]*Image{}
+	var cur []*Image
+	for _, i := range byTime {
+		if len(cur) > 0 && !sameBurst(cur[len(cur)-1], i, c.StackWindow, threshold) {
+			bursts = append(bursts, cur)
+			cur = nil
+		}
+		cur = append(cur, i)
+	}
+	bursts = append(bursts, cur)
+
+	// frames which are not representatives are removed from the album
+	stacked := map[*Image]bool{}
+	for _, b := range bursts {
+		if len(b) < 2 {
+			continue
+		}
+
+		rep := bestFrame(b)
+		frames := []*Image{}
+		for _, i := range b {
+			if i != rep {
+				frames = append(frames, i)
+				stacked[i] = true
+			}
+		}
+		if a.stacks == nil {
+			a.stacks = map[*Image][]*Image{}
+		}
+		a.stacks[rep] = frames
+		klog.V(1).Infof("%s: stacked %d frames under %s", a.Title, len(b), rep.BasePath)
+	}
+
+	if len(stacked) == 0 {
+		return
+	}
+
+	is := []*Image{}
+	for _, i := range a.Images {
+		if !stacked[i] {
+			is = append(is, i)
+		}
+	}
+	a.Images = is
+}
+
+// Frames returns every frame of the burst an image represents within the album, starting with the
+// image itself, or nil if it represents none.
+func (a *Album) Frames(i *Image) []*Image {
+	fs := a.stacks[i]
+	if len(fs) == 0 {
+		return nil
+	}
+	return append([]*Image{i}, fs...)
+}
+
+// stackID returns the gallery album ID of the burst represented by rep.
+func stackID(rep *Image) string {
+	return "stack-" + rep.BasePath
+}
+
+// sameBurst returns true if next was taken within window of prev, by the same camera, and differs
+// from it in no more than threshold bits of perceptual hash.
+func sameBurst(prev, next *Image, window time.Duration, threshold int) bool {
+	if prev.Taken.IsZero() || next.Taken.IsZero() || next.Taken.Sub(prev.Taken) > window {
+		return false
+	}
+	if prev.Video || next.Video || prev.Make != next.Make || prev.Model != next.Model {
+		return false
+	}
+	if prev.DHash == "" || next.DHash == "" {
+		return false
+	}
+	return bits.OnesCount64(parseDHash(prev.DHash)^parseDHash(next.DHash)) <= threshold
+}
+
+// bestFrame returns the frame which represents a burst: a highlight or favorite, or else the
+// best rated, or else the sharpest. Rejected frames are only chosen if every frame is rejected.
+func bestFrame(is []*Image) *Image {
+	best := is[0]
+	for _, i := range is[1:] {
+		switch {
+		case rejected(i) != rejected(best):
+			if rejected(best) {
+				best = i
+			}
+		case picked(i) != picked(best):
+			if picked(i) {
+				best = i
+			}
+		case i.Rating != best.Rating:
+			if i.Rating > best.Rating {
+				best = i
+			}
+		case i.Sharpness > best.Sharpness:
+			best = i
+		}
+	}
+	return best
+}
+
+// picked returns true if an image has been marked as a highlight or favorite, and not rejected.
+func picked(i *Image) bool {
+	return (i.Highlight || i.Favorite) && !rejected(i)
+}
+
+// sharpness returns the variance of the Laplacian of a reduced grayscale copy of an image.
+// Higher values indicate more fine detail, and so a sharper frame.
+func sharpness(img image.Image) float64 {
+	b := img.Bounds()
+	if b.Dx() == 0 {
+		return 0
+	}
+	h := b.Dy() * sharpnessWidth / b.Dx()
+	if h < 3 {
+		return 0
+	}
+	small := transform.Resize(img, sharpnessWidth, h, transform.Linear)
+
+	gray := make([][]float64, h)
+	for y := range h {
+		gray[y] = make([]float64, sharpnessWidth)
+		for x := range sharpnessWidth {
+			gray[y][x] = float64(luma(small, x, y)) / 65535
+		}
+	}
+
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < sharpnessWidth-1; x++ {
+			l := gray[y-1][x] + gray[y+1][x] + gray[y][x-1] + gray[y][x+1] - 4*gray[y][x]
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
@@ -0,0 +1,214 @@
+package livstid
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	// takeoutNameLimit is the length at which Takeout truncates sidecar file names.
+	takeoutNameLimit = 51
+	// takeoutSuffix is inserted before ".json" in the sidecar names of newer exports.
+	takeoutSuffix = ".supplemental-metadata"
+	// takeoutCounter matches the counter Takeout adds to files which share a name, as in IMG_1234(1).jpg.
+	takeoutCounter = regexp.MustCompile(`\(\d+\)$`)
+)
+
+// TakeoutSidecar is a JSON file for EXIF overrides that is compatible with Google Takeout.
+type TakeoutSidecar struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// PhotoTakenTime is when the photo was taken, which Takeout often keeps only here.
+	PhotoTakenTime TakeoutTime `json:"photoTakenTime"`
+	CreationTime   TakeoutTime `json:"creationTime"`
+	// GeoData is the location of the photo, including any edits made in Google Photos.
+	GeoData TakeoutGeo `json:"geoData"`
+	// GeoDataExif is the location recorded in the original file.
+	GeoDataExif TakeoutGeo      `json:"geoDataExif"`
+	People      []TakeoutPerson `json:"people"`
+	Favorited   bool            `json:"favorited"`
+	// Not compatible
+	Tags []string `json:"tags"`
+}
+
+// TakeoutTime is a timestamp within a Takeout sidecar.
+type TakeoutTime struct {
+	// Timestamp is in seconds since the Unix epoch.
+	Timestamp string `json:"timestamp"`
+	Formatted string `json:"formatted"`
+}
+
+// Time returns the time in UTC, or the zero time if it is unset.
+func (t TakeoutTime) Time() time.Time {
+	if t.Timestamp == "" {
+		return time.Time{}
+	}
+	s, err := strconv.ParseInt(t.Timestamp, 10, 64)
+	if err != nil || s == 0 {
+		klog.Warningf("invalid Takeout timestamp %q: %v", t.Timestamp, err)
+		return time.Time{}
+	}
+	return time.Unix(s, 0).UTC()
+}
+
+// TakeoutGeo is a location within a Takeout sidecar. Unknown locations are recorded as 0,0.
+type TakeoutGeo struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// Coordinates returns the location, or nil if it is unknown.
+func (g TakeoutGeo) Coordinates() *Coordinates {
+	if g.Latitude == 0 && g.Longitude == 0 {
+		return nil
+	}
+	return &Coordinates{Latitude: g.Latitude, Longitude: g.Longitude, Altitude: g.Altitude}
+}
+
+// TakeoutPerson is a person tagged within a Takeout sidecar.
+type TakeoutPerson struct {
+	Name string `json:"name"`
+}
+
+// processSidecars applies the Takeout sidecar of an image, if it is among jsons: the names of the
+// JSON files in its directory. The sidecar's description is used as the title, and its other
+// values fill gaps in the image's own metadata.
+func processSidecars(i *Image, jsons []string) error {
+	name := takeoutSidecar(filepath.Base(i.InPath), i.Video, jsons)
+	if name == "" {
+		return nil
+	}
+
+	bs, err := os.ReadFile(filepath.Join(filepath.Dir(i.InPath), name))
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+
+	side := &TakeoutSidecar{}
+	if err := json.Unmarshal(bs, side); err != nil {
+		return fmt.Errorf("unmarshal %s: %w", name, err)
+	}
+	klog.V(1).Infof("%s: applying Takeout sidecar %s", i.BasePath, name)
+
+	if side.Description != "" {
+		i.Title = side.Description
+		klog.Infof("%s: found sidecar title: %q", i.BasePath, i.Title)
+	}
+
+	if i.Taken.IsZero() {
+		i.Taken = side.PhotoTakenTime.Time()
+		i.UTC = !i.Taken.IsZero()
+	}
+
+	if i.GPS == nil {
+		i.GPS = side.GeoData.Coordinates()
+	}
+	if i.GPS == nil {
+		i.GPS = side.GeoDataExif.Coordinates()
+	}
+
+	if len(i.People) == 0 {
+		for _, p := range side.People {
+			i.People = append(i.People, p.Name)
+		}
+	}
+
+	if side.Favorited {
+		i.Favorite = true
+	}
+	for _, k := range side.Tags {
+		if !slices.Contains(i.Keywords, k) {
+			i.Keywords = append(i.Keywords, k)
+		}
+	}
+	return nil
+}
+
+// takeoutSidecar returns the name of the Takeout sidecar of an image among jsons, or "" if there is none.
+// Besides IMG_1234.jpg.json, Takeout produces:
+//
+//   - IMG_1234.jpg.supplemental-metadata.json, in newer exports
+//   - names truncated to 51 characters, such as IMG_1234.jpg.supplemental-met.json
+//   - IMG_1234.jpg(1).json, for IMG_1234(1).jpg
+//   - the sidecar of the original, for edited copies such as IMG_1234-edited.jpg
+//   - the sidecar of the still, for the video of a live photo such as IMG_1234.mp4
+func takeoutSidecar(name string, video bool, jsons []string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	counter := takeoutCounter.FindString(stem)
+	stem = strings.TrimSuffix(stem, counter)
+
+	origs := []string{stem + ext}
+	if s, ok := strings.CutSuffix(stem, "-edited"); ok {
+		origs = append(origs, s+ext)
+	}
+
+	for _, orig := range origs {
+		if j := takeoutMatch(orig, counter, jsons); j != "" {
+			return j
+		}
+	}
+
+	if video {
+		for _, still := range takeoutStills(stem, jsons) {
+			if j := takeoutMatch(still, counter, jsons); j != "" {
+				return j
+			}
+		}
+	}
+	return ""
+}
+
+// takeoutMatch returns the name of the sidecar of orig among jsons, or "" if there is none.
+func takeoutMatch(orig, counter string, jsons []string) string {
+	// prefer exact names over truncated ones
+	for _, j := range []string{orig + counter + ".json", orig + takeoutSuffix + counter + ".json"} {
+		if slices.Contains(jsons, j) {
+			return j
+		}
+	}
+	for _, j := range jsons {
+		if takeoutTruncated(orig, counter, j) {
+			return j
+		}
+	}
+	return ""
+}
+
+// takeoutStills returns the names of the photos with the given stem which jsons are sidecars of,
+// such as IMG_1234.HEIC for IMG_1234.HEIC.supplemental-metadata.json.
+func takeoutStills(stem string, jsons []string) []string {
+	stills := []string{}
+	for _, j := range jsons {
+		rest, ok := strings.CutPrefix(j, stem+".")
+		if !ok {
+			continue
+		}
+		ext, _, _ := strings.Cut(rest, ".")
+		ext = takeoutCounter.ReplaceAllString(ext, "")
+		still := stem + "." + ext
+		if f := formatFor(still); f != nil && !f.Video && !slices.Contains(stills, still) {
+			stills = append(stills, still)
+		}
+	}
+	return stills
+}
+
+// takeoutTruncated returns true if j is a truncated name of the sidecar of orig.
+func takeoutTruncated(orig, counter, j string) bool {
+	k, ok := strings.CutSuffix(strings.TrimSuffix(j, filepath.Ext(j)), counter)
+	if !ok || (len(k) < len(orig) && len(k)+len(".json") < takeoutNameLimit) {
+		return false
+	}
+	return strings.HasPrefix(orig+takeoutSuffix, k)
+}
@@ -0,0 +1,35 @@
+package livstid
+
+import "testing"
+
+func TestTakeoutSidecar(t *testing.T) {
+	long := "PXL_20230101_123456789.PORTRAIT.ORIGINAL_NAME.jpg"
+
+	tests := []struct {
+		name  string
+		video bool
+		jsons []string
+		want  string
+	}{
+		{name: "IMG_1.jpg", jsons: []string{"IMG_1.jpg.json"}, want: "IMG_1.jpg.json"},
+		{name: "IMG_1.jpg", jsons: []string{"IMG_1.jpg.supplemental-metadata.json"}, want: "IMG_1.jpg.supplemental-metadata.json"},
+		{name: "IMG_1.jpg", jsons: []string{"IMG_1.jpg.supplemental-met.json"}, want: "IMG_1.jpg.supplemental-met.json"},
+		{name: "IMG_1.jpg", jsons: []string{"IMG_1.jpg.supp.json", "IMG_1.jpg.json"}, want: "IMG_1.jpg.json"},
+		{name: long, jsons: []string{long[:46] + ".json"}, want: long[:46] + ".json"},
+		{name: "IMG_1(1).jpg", jsons: []string{"IMG_1.jpg.json", "IMG_1.jpg(1).json"}, want: "IMG_1.jpg(1).json"},
+		{name: "IMG_1.jpg", jsons: []string{"IMG_1.jpg(1).json"}, want: ""},
+		{name: "IMG_1-edited.jpg", jsons: []string{"IMG_1.jpg.json"}, want: "IMG_1.jpg.json"},
+		{name: "IMG_1.jpg", jsons: []string{"IMG_12.jpg.json", "metadata.json"}, want: ""},
+		{name: "IMG_1.MP4", video: true, jsons: []string{"IMG_1.HEIC.json"}, want: "IMG_1.HEIC.json"},
+		{name: "IMG_1.MP4", video: true, jsons: []string{"IMG_1.HEIC.supplemental-metadata.json"}, want: "IMG_1.HEIC.supplemental-metadata.json"},
+		{name: "IMG_1.MP4", video: true, jsons: []string{"IMG_1.HEIC.supplemental-met.json"}, want: "IMG_1.HEIC.supplemental-met.json"},
+		{name: "IMG_1(1).MP4", video: true, jsons: []string{"IMG_1.HEIC.json", "IMG_1.HEIC(1).json"}, want: "IMG_1.HEIC(1).json"},
+		{name: "IMG_1.MP4", video: true, jsons: []string{"IMG_12.HEIC.json"}, want: ""},
+		{name: "IMG_1.MP4", jsons: []string{"IMG_1.HEIC.json"}, want: ""},
+	}
+	for _, tc := range tests {
+		if got := takeoutSidecar(tc.name, tc.video, tc.jsons); got != tc.want {
+			t.Errorf("takeoutSidecar(%q, %v, %v) = %q, want %q", tc.name, tc.video, tc.jsons, got, tc.want)
+		}
+	}
+}
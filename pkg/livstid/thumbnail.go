@@ -5,11 +5,12 @@ import (
 	"image"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/anthonynsimon/bild/imgio"
 	"github.com/anthonynsimon/bild/transform"
-	"github.com/otiai10/copy"
 	"k8s.io/klog/v2"
 )
 
@@ -27,45 +28,87 @@ type ThumbOpts struct {
 	Quality int
 }
 
-func thumbnails(i *Image, opts map[string]ThumbOpts, outDir string) (map[string]ThumbMeta, error) {
-	klog.V(1).Infof("creating thumbnails for %s in %s", i.InPath, outDir)
-	fullDest := filepath.Join(outDir, urlSafePath(i.RelPath))
-	klog.V(1).Infof("relpath: %s -- full dest: %s", i.RelPath, fullDest)
-
-	sst, err := os.Stat(i.InPath)
-	if err != nil {
-		return nil, fmt.Errorf("stat: %w", err)
+// generateThumbnails creates thumbnails for all images using a bounded pool of workers.
+// Images which fail are omitted from the returned slice, and their errors returned alongside.
+// If republish is set, files which are already published are written again.
+func generateThumbnails(is []*Image, c *Config, republish bool) ([]*Image, []error) {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	klog.Infof("generating thumbnails for %d images using %d workers ...", len(is), workers)
+
+	errs := make([]error, len(is))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range idx {
+				i := is[n]
+				klog.V(1).Infof("build image: %+v", i)
+				thumbs, err := thumbnails(i, c, republish)
+				if err != nil {
+					errs[n] = fmt.Errorf("thumbnails for %s: %w", i.InPath, err)
+					continue
+				}
+				i.Resize = thumbs
+			}
+		}()
 	}
 
-	dst, err := os.Stat(fullDest)
-	updated := false
-
-	if err != nil {
-		updated = true
-		klog.V(1).Infof("updating %s: does not exist", fullDest)
+	for n := range is {
+		idx <- n
+	}
+	close(idx)
+	wg.Wait()
+
+	ok := []*Image{}
+	failed := []error{}
+	for n, i := range is {
+		if errs[n] != nil {
+			klog.Errorf("skipping image: %v", errs[n])
+			failed = append(failed, errs[n])
+			continue
+		}
+		ok = append(ok, i)
 	}
+	return ok, failed
+}
 
-	if err == nil && sst.Size() != dst.Size() {
-		updated = true
-		klog.Infof("updating %s: size mismatch (%d to %d)", fullDest, sst.Size(), dst.Size())
+func thumbnails(i *Image, c *Config, republish bool) (map[string]ThumbMeta, error) {
+	outDir := c.OutDir
+	klog.V(1).Infof("creating thumbnails for %s in %s", i.InPath, outDir)
+	fullDest := filepath.Join(outDir, publishRelPath(i, c))
+	klog.V(1).Infof("relpath: %s -- full dest: %s", i.RelPath, fullDest)
+
+	f := formatFor(i.InPath)
+	if f == nil {
+		return nil, fmt.Errorf("unsupported format: %s", i.InPath)
 	}
 
-	if err == nil && sst.ModTime().After(dst.ModTime()) {
-		klog.Infof("updating %s: source newer", fullDest)
-		updated = true
+	// Transcoded or derived files never match the size of their source
+	verbatim := f.WebSafe && !(f.Video && c.TranscodeVideos) && !rewritesOriginals(c)
+	updated, err := needsUpdate(i.InPath, fullDest, verbatim)
+	if err != nil {
+		return nil, err
 	}
+	updated = updated || republish
 
+	var img image.Image
 	if updated {
-		err := copy.Copy(i.InPath, fullDest)
+		img, err = publish(i, fullDest, c)
 		if err != nil {
-			return nil, fmt.Errorf("copy: %w", err)
+			return nil, err
 		}
 	}
 
-	var img image.Image
 	thumbs := map[string]ThumbMeta{}
+	created := []string{}
 
-	for name, t := range opts {
+	for name, t := range c.Thumbnails {
 		relPath := thumbRelPath(i, t)
 		klog.V(1).Infof("thumb relpath: %s", relPath)
 		fullPath := filepath.Join(outDir, relPath)
@@ -88,9 +131,9 @@ func thumbnails(i *Image, opts map[string]ThumbOpts, outDir string) (map[string]
 		}
 
 		if img == nil {
-			img, err = imgio.Open(i.InPath)
+			img, err = decodeSource(i)
 			if err != nil {
-				return nil, fmt.Errorf("imgio.Open: %w", err)
+				return nil, err
 			}
 		}
 
@@ -102,9 +145,16 @@ func thumbnails(i *Image, opts map[string]ThumbOpts, outDir string) (map[string]
 
 		ct.RelPath = relPath
 		thumbs[name] = *ct
+		created = append(created, fullPath)
 		klog.V(1).Infof("created thumb: %+v", ct)
 	}
 
+	if len(created) > 0 {
+		if err := sanitize(i, c, i.InPath, thumbnailTags(c), created...); err != nil {
+			return nil, fmt.Errorf("thumbnail metadata: %w", err)
+		}
+	}
+
 	return thumbs, nil
 }
 
@@ -162,8 +212,11 @@ func readThumb(path string) (*ThumbMeta, error) {
 // thumbRelPath returns a relative path to a thumbnail, optimizing for both cache busting and SEO.
 func thumbRelPath(i *Image, t ThumbOpts) string {
 	base := filepath.Base(i.RelPath)
-	ext := filepath.Ext(base)
-	noExt := strings.TrimSuffix(base, ext)
+	noExt := base
+	// Only JPEG extensions are dropped, so that photo.heic and photo.jpg don't share thumbnails
+	if isJPEG(base) {
+		noExt = strings.TrimSuffix(base, filepath.Ext(base))
+	}
 
 	thumbDir := filepath.Join(filepath.Dir(i.RelPath), "_")
 	dimensions := ""
@@ -174,6 +227,11 @@ func thumbRelPath(i *Image, t ThumbOpts) string {
 		dimensions = fmt.Sprintf("y%d", t.Y)
 	}
 
+	// Rotated images get a distinct name so that thumbnails created before orientation support are replaced
+	if i.Orientation > 1 {
+		dimensions = fmt.Sprintf("%s_o%d", dimensions, i.Orientation)
+	}
+
 	// ModTimeFormat is important to catch minor adjustments
 	newBase := fmt.Sprintf("%s@%s_%s.jpg", noExt, dimensions, i.ModTime.Format(ModTimeFormat))
 	return urlSafePath(filepath.Join(thumbDir, newBase))
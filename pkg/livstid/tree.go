@@ -0,0 +1,140 @@
+package livstid
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// AlbumNode is a directory in the album hierarchy, which may have an album of its own,
+// nested albums, or both.
+type AlbumNode struct {
+	Name string
+	// Label overrides the title of the node, such as that of an input root.
+	Label string
+	// URL is the path to the node's page, relative to the output directory.
+	URL string
+	// Album is the album of images within the directory itself, if any.
+	Album *Album
+	// HierAlbum holds every image beneath the directory, if it has nested albums.
+	HierAlbum *Album
+	Children  []*AlbumNode
+}
+
+// Title returns the title of the node, preferring its label, then that of its own album.
+func (n *AlbumNode) Title() string {
+	if n.Label != "" {
+		return n.Label
+	}
+	if n.Album != nil {
+		return n.Album.Title
+	}
+	return n.Name
+}
+
+// Cover returns an image which represents the node: its album's cover, or the cover of its
+// first nested album, or failing that the first image found.
+func (n *AlbumNode) Cover() *Image {
+	if n.Album != nil && n.Album.Cover != nil {
+		return n.Album.Cover
+	}
+	for _, c := range n.Children {
+		if i := c.Cover(); i != nil {
+			return i
+		}
+	}
+	if n.Album != nil && len(n.Album.Images) > 0 {
+		return n.Album.Images[0]
+	}
+	return nil
+}
+
+// DateRange returns the date range of every image within and beneath the node.
+func (n *AlbumNode) DateRange() string {
+	var start, end time.Time
+	for _, a := range []*Album{n.Album, n.HierAlbum} {
+		if a == nil || a.StartTime.IsZero() {
+			continue
+		}
+		if start.IsZero() || a.StartTime.Before(start) {
+			start = a.StartTime
+		}
+		if a.EndTime.After(end) {
+			end = a.EndTime
+		}
+	}
+	return dateRange(start, end)
+}
+
+// Crumbs returns the names of the directories in the album's hierarchy for navigation.
+func (a *Album) Crumbs() []string {
+	if a.crumbs != nil {
+		return a.crumbs
+	}
+	return a.Hier
+}
+
+// applyLabels names the directories of albums in navigation after the labels of input roots, which
+// are keyed by slash-separated path. The first skip directories of each album's hierarchy, such as
+// "events", are not part of that path.
+func applyLabels(as []*Album, labels map[string]string, skip int) {
+	if len(labels) == 0 {
+		return
+	}
+	for _, a := range as {
+		cs := slices.Clone(a.Hier)
+		for n := skip; n < len(cs); n++ {
+			if l, ok := labels[strings.Join(a.Hier[skip:n+1], "/")]; ok {
+				cs[n] = l
+			}
+		}
+		a.crumbs = cs
+	}
+}
+
+// buildAlbumTree arranges albums into a tree according to their hierarchy, preserving their order.
+// Hidden albums are left out, along with any directories which only contain hidden albums.
+// Nodes are labeled from labels, which is keyed by slash-separated path.
+func buildAlbumTree(as []*Album, hierAlbums []*Album, labels map[string]string) *AlbumNode {
+	root := &AlbumNode{}
+	nodes := map[string]*AlbumNode{"": root}
+
+	var nodeFor func(hier []string) *AlbumNode
+	nodeFor = func(hier []string) *AlbumNode {
+		key := strings.Join(hier, "/")
+		if n, ok := nodes[key]; ok {
+			return n
+		}
+		parent := nodeFor(hier[:len(hier)-1])
+		n := &AlbumNode{Name: hier[len(hier)-1], Label: labels[key], URL: urlSafePath(key) + "/"}
+		parent.Children = append(parent.Children, n)
+		nodes[key] = n
+		return n
+	}
+
+	for _, a := range as {
+		if a.Hidden {
+			continue
+		}
+		nodeFor(a.Hier).Album = a
+	}
+
+	for _, a := range hierAlbums {
+		if n, ok := nodes[strings.Join(a.Hier, "/")]; ok {
+			n.HierAlbum = a
+		}
+	}
+
+	for _, n := range nodes {
+		if n.Album != nil {
+			n.Album.Children = n.Children
+		}
+		if n.HierAlbum != nil {
+			n.HierAlbum.Children = n.Children
+			if n.Label != "" {
+				n.HierAlbum.Title = n.Label
+			}
+		}
+	}
+	return root
+}
@@ -0,0 +1,131 @@
+package livstid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthonynsimon/bild/imgio"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// TranscodeTimeout is the maximum amount of time to spend transcoding a single video.
+	TranscodeTimeout = 30 * time.Minute
+
+	// TranscodeArgs are the ffmpeg output arguments used to create web renditions of videos.
+	TranscodeArgs = []string{
+		"-c:v", "libx264", "-preset", "medium", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-vf", "scale='min(1920,iw)':-2",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart",
+		// drop container metadata such as embedded locations
+		"-map_metadata", "-1",
+	}
+)
+
+// ffmpegPath returns the path to ffmpeg.
+func ffmpegPath() (string, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", errors.New("ffmpeg not installed in $PATH")
+	}
+	return path, nil
+}
+
+// decodePoster extracts a representative frame from a video for use as a thumbnail.
+// ffmpeg applies any rotation metadata, so the result is already oriented.
+func decodePoster(path string) (image.Image, error) {
+	bin, err := ffmpegPath()
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.MkdirTemp("", "livstid")
+	if err != nil {
+		return nil, fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmp); err != nil {
+			klog.Errorf("Failed to remove %s: %v", tmp, err)
+		}
+	}()
+
+	out := filepath.Join(tmp, "poster.jpg")
+	ctx, cancel := context.WithTimeout(context.Background(), ConvertTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin, "-hide_banner", "-loglevel", "error", "-y",
+		"-i", path, "-vf", "thumbnail", "-frames:v", "1", "-q:v", "2", out)
+	klog.V(1).Infof("extracting poster: %s", cmd)
+	if bs, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", cmd, err, bs)
+	}
+	return imgio.Open(out)
+}
+
+// transcode creates a web-friendly MP4 rendition of a video at dest.
+func transcode(src string, dest string) error {
+	bin, err := ffmpegPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil { //nolint:gosec // directory permissions are standard
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	// write to a temporary file so that an interrupted transcode is never published
+	tmp := dest + ".tmp.mp4"
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", src}
+	args = append(args, TranscodeArgs...)
+	args = append(args, tmp)
+
+	ctx, cancel := context.WithTimeout(context.Background(), TranscodeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, bin, args...)
+	klog.Infof("transcoding %s -> %s ...", src, dest)
+	if bs, err := cmd.CombinedOutput(); err != nil {
+		if rerr := os.Remove(tmp); rerr != nil {
+			klog.Warningf("unable to remove %s: %v", tmp, rerr)
+		}
+		return fmt.Errorf("%s failed: %w: %s", cmd, err, bs)
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	klog.Infof("transcoded %s in %s", src, time.Since(start))
+	return nil
+}
+
+// parseExifDuration parses durations as printed by exiftool, such as "12.34 s" or "0:01:23".
+func parseExifDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "(approx)"))
+	if strings.HasSuffix(s, " s") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, " s"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse seconds: %w", err)
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+
+	var d time.Duration
+	for _, p := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse %q: %w", s, err)
+		}
+		d = d*60 + time.Duration(n*float64(time.Second))
+	}
+	return d, nil
+}
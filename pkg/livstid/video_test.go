@@ -0,0 +1,31 @@
+package livstid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExifDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "12.5 s", want: 12500 * time.Millisecond},
+		{in: "0.5 s (approx)", want: 500 * time.Millisecond},
+		{in: "0:01:23", want: 83 * time.Second},
+		{in: "1:02:03", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{in: "", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseExifDuration(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseExifDuration(%q) error = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseExifDuration(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
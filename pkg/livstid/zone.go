@@ -0,0 +1,169 @@
+package livstid
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"k8s.io/klog/v2"
+)
+
+var (
+	// maxOffset is the largest offset from UTC of any time zone.
+	maxOffset = 14 * time.Hour
+	// offsetStep is the granularity of time zone offsets, which GPS-derived offsets are rounded to.
+	offsetStep = 15 * time.Minute
+)
+
+// readZone sets the time zone of a capture time read from EXIF, which has none of its own, from
+// OffsetTimeOriginal, or else from the difference between it and the GPS timestamp.
+func readZone(i *Image, fi exiftool.FileMetadata) {
+	if s, err := fi.GetString("OffsetTimeOriginal"); err == nil {
+		off, err := parseOffset(s)
+		if err == nil {
+			i.Taken = inZone(i.Taken, time.FixedZone("", int(off.Seconds())))
+			i.Zoned = true
+			return
+		}
+		klog.V(1).Infof("unable to parse OffsetTimeOriginal %q: %v", s, err)
+	}
+
+	s, err := fi.GetString("GPSDateTime")
+	if err != nil {
+		return
+	}
+	gps, err := time.Parse(exifDate, strings.TrimSuffix(strings.SplitN(s, ".", 2)[0], "Z"))
+	if err != nil {
+		klog.V(1).Infof("unable to parse GPSDateTime %q: %v", s, err)
+		return
+	}
+
+	// the capture time is local, and the GPS time is UTC
+	off := i.Taken.Sub(gps).Round(offsetStep)
+	if off < -maxOffset || off > maxOffset {
+		klog.V(1).Infof("ignoring implausible GPS time offset %s", off)
+		return
+	}
+	i.Taken = inZone(i.Taken, time.FixedZone("", int(off.Seconds())))
+	i.Zoned = true
+}
+
+// parseOffset parses an EXIF offset such as "+02:00".
+func parseOffset(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if len(s) != 6 || (s[0] != '+' && s[0] != '-') || s[3] != ':' {
+		return 0, fmt.Errorf("unexpected offset format: %q", s)
+	}
+	h, err := strconv.Atoi(s[1:3])
+	if err != nil {
+		return 0, fmt.Errorf("hours: %w", err)
+	}
+	m, err := strconv.Atoi(s[4:6])
+	if err != nil {
+		return 0, fmt.Errorf("minutes: %w", err)
+	}
+
+	off := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	if s[0] == '-' {
+		off = -off
+	}
+	return off, nil
+}
+
+// inZone returns the same wall clock time in loc.
+func inZone(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// displayLocation returns the time zone which capture times are displayed in, or nil to display
+// each in its own zone.
+func displayLocation(c *Config) (*time.Location, error) {
+	if c.DisplayTimezone == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(c.DisplayTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("display timezone: %w", err)
+	}
+	return loc, nil
+}
+
+// zoneTimes places capture times in the time zone set by their album's metadata, if any. Times
+// known only in UTC, such as those of videos, and wall clock times otherwise take the zone of the
+// photo taken nearest to them in the same directory, or else fallback, or the local time zone if
+// fallback is nil, so that every time can be sorted by the instant it was taken at.
+func zoneTimes(is []*Image, fallback *time.Location) {
+	if fallback == nil {
+		fallback = time.Local
+	}
+
+	byDir := map[string][]*Image{}
+	for _, i := range is {
+		if !i.Taken.IsZero() {
+			byDir[filepath.Dir(i.InPath)] = append(byDir[filepath.Dir(i.InPath)], i)
+		}
+	}
+
+	for dir, dis := range byDir {
+		// errors are reported when the album is built
+		m, _ := readAlbumMeta(dir)
+		if m != nil && m.location != nil {
+			for _, i := range dis {
+				setZone(i, m.location)
+			}
+			continue
+		}
+
+		zoned := []*Image{}
+		for _, i := range dis {
+			if i.Zoned {
+				zoned = append(zoned, i)
+			}
+		}
+		for _, i := range dis {
+			if i.Zoned {
+				continue
+			}
+			loc := fallback
+			var nearest time.Duration = -1
+			for _, o := range zoned {
+				if d := o.Taken.Sub(i.Taken).Abs(); nearest < 0 || d < nearest {
+					loc, nearest = o.Taken.Location(), d
+				}
+			}
+			setZone(i, loc)
+		}
+	}
+}
+
+// setZone places a capture time in loc. Times with a known zone or in UTC are converted to it,
+// and wall clock times are assumed to be in it.
+func setZone(i *Image, loc *time.Location) {
+	if i.Zoned || i.UTC {
+		i.Taken = i.Taken.In(loc)
+	} else {
+		i.Taken = inZone(i.Taken, loc)
+	}
+	i.Zoned, i.UTC = true, false
+}
+
+// localizeTimes converts capture times to the display time zone, if any. Times without a known
+// zone are assumed to already be in it.
+func localizeTimes(is []*Image, loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	for _, i := range is {
+		if i.Taken.IsZero() {
+			continue
+		}
+		if i.Zoned {
+			i.Taken = i.Taken.In(loc)
+			continue
+		}
+		i.Taken = inZone(i.Taken, loc)
+	}
+}
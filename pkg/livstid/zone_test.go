@@ -0,0 +1,161 @@
+package livstid
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+)
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "+02:00", want: 2 * time.Hour},
+		{in: "-05:30", want: -5*time.Hour - 30*time.Minute},
+		{in: " +09:00 ", want: 9 * time.Hour},
+		{in: "+00:00", want: 0},
+		{in: "02:00", wantErr: true},
+		{in: "+2:00", wantErr: true},
+		{in: "+ab:00", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tc := range tests {
+		got, err := parseOffset(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseOffset(%q) error = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseOffset(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestReadZone(t *testing.T) {
+	taken := time.Date(2024, 6, 3, 19, 15, 30, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		zoned  bool
+		offset time.Duration
+	}{
+		{name: "offset", fields: map[string]interface{}{"OffsetTimeOriginal": "+09:00"}, zoned: true, offset: 9 * time.Hour},
+		{name: "negative offset", fields: map[string]interface{}{"OffsetTimeOriginal": "-05:30"}, zoned: true, offset: -5*time.Hour - 30*time.Minute},
+		{name: "GPS time", fields: map[string]interface{}{"GPSDateTime": "2024:06:03 10:14:02.5Z"}, zoned: true, offset: 9 * time.Hour},
+		{name: "GPS time off by minutes", fields: map[string]interface{}{"GPSDateTime": "2024:06:03 13:52:00Z"}, zoned: true, offset: 5*time.Hour + 30*time.Minute},
+		{name: "implausible GPS time", fields: map[string]interface{}{"GPSDateTime": "2024:01:03 10:14:02Z"}},
+		{name: "invalid offset falls back to GPS", fields: map[string]interface{}{"OffsetTimeOriginal": "?", "GPSDateTime": "2024:06:03 17:15:30Z"}, zoned: true, offset: 2 * time.Hour},
+		{name: "nothing", fields: map[string]interface{}{}},
+	}
+	for _, tc := range tests {
+		i := &Image{Taken: taken}
+		readZone(i, exiftool.FileMetadata{Fields: tc.fields})
+		if i.Zoned != tc.zoned {
+			t.Errorf("%s: zoned = %v, want %v", tc.name, i.Zoned, tc.zoned)
+			continue
+		}
+		if _, off := i.Taken.Zone(); time.Duration(off)*time.Second != tc.offset {
+			t.Errorf("%s: offset = %ds, want %s", tc.name, off, tc.offset)
+		}
+		if h, m := i.Taken.Hour(), i.Taken.Minute(); h != 19 || m != 15 {
+			t.Errorf("%s: wall clock changed to %s", tc.name, i.Taken)
+		}
+	}
+}
+
+func TestZoneTimes(t *testing.T) {
+	tokyo := time.FixedZone("", 9*3600)
+	nyc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	utc := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+
+	trip := t.TempDir()
+	if err := os.WriteFile(filepath.Join(trip, "album.yaml"), []byte("timezone: Asia/Tokyo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		dir      string
+		zoned    []*Image
+		image    *Image
+		fallback *time.Location
+		want     time.Time
+	}{
+		{
+			name:  "video near a zoned photo",
+			zoned: []*Image{{Taken: utc.In(tokyo), Zoned: true}},
+			image: &Image{Taken: utc.Add(time.Hour), UTC: true},
+			want:  time.Date(2024, 6, 3, 20, 0, 0, 0, tokyo),
+		},
+		{
+			name:  "wall clock near a zoned photo",
+			zoned: []*Image{{Taken: utc.In(tokyo), Zoned: true}},
+			image: &Image{Taken: time.Date(2024, 6, 3, 20, 0, 0, 0, time.UTC)},
+			want:  time.Date(2024, 6, 3, 20, 0, 0, 0, tokyo),
+		},
+		{
+			name:     "video with a fallback",
+			image:    &Image{Taken: utc, UTC: true},
+			fallback: nyc,
+			want:     time.Date(2024, 6, 3, 6, 0, 0, 0, nyc),
+		},
+		{
+			name:     "wall clock with a fallback",
+			image:    &Image{Taken: utc},
+			fallback: nyc,
+			want:     time.Date(2024, 6, 3, 10, 0, 0, 0, nyc),
+		},
+		{
+			name:  "wall clock without a fallback",
+			image: &Image{Taken: utc},
+			want:  time.Date(2024, 6, 3, 10, 0, 0, 0, time.Local),
+		},
+		{
+			name:     "album zone converts",
+			dir:      trip,
+			zoned:    []*Image{{Taken: time.Date(2024, 6, 3, 12, 0, 0, 0, time.FixedZone("", 2*3600)), Zoned: true}},
+			image:    &Image{Taken: time.Date(2024, 6, 3, 12, 0, 0, 0, time.FixedZone("", 2*3600)), Zoned: true},
+			fallback: nyc,
+			want:     time.Date(2024, 6, 3, 19, 0, 0, 0, tokyo),
+		},
+		{
+			name:     "album zone assumed",
+			dir:      trip,
+			image:    &Image{Taken: utc},
+			fallback: nyc,
+			want:     time.Date(2024, 6, 3, 10, 0, 0, 0, tokyo),
+		},
+	}
+	for _, tc := range tests {
+		dir := tc.dir
+		if dir == "" {
+			dir = t.TempDir()
+		}
+		is := []*Image{}
+		for n, i := range append(tc.zoned, tc.image) {
+			i.InPath = filepath.Join(dir, fmt.Sprintf("%d.jpg", n))
+			is = append(is, i)
+		}
+
+		zoneTimes(is, tc.fallback)
+		if !tc.image.Taken.Equal(tc.want) {
+			t.Errorf("%s: taken at %s, want %s", tc.name, tc.image.Taken, tc.want)
+		}
+		if h := tc.image.Taken.Hour(); h != tc.want.Hour() {
+			t.Errorf("%s: shown at hour %d, want %d", tc.name, h, tc.want.Hour())
+		}
+		if !tc.image.Zoned || tc.image.UTC {
+			t.Errorf("%s: zoned = %v, UTC = %v, want zoned", tc.name, tc.image.Zoned, tc.image.UTC)
+		}
+	}
+}
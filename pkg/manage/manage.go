@@ -2,8 +2,14 @@
 package manage
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sync"
 
+	"github.com/barasher/go-exiftool"
 	"github.com/tstromberg/livstid/pkg/livstid"
 	"k8s.io/klog/v2"
 )
@@ -11,7 +17,9 @@ import (
 // Server is a server for the pullsheet web app.
 type Server struct {
 	c    *livstid.Config
+	a    *livstid.Assembly
 	path string
+	mu   sync.Mutex
 }
 
 // New creates a new server.
@@ -23,9 +31,117 @@ func New(c *livstid.Config, path string) *Server {
 	return server
 }
 
+// SetAssembly sets the most recently built assembly, which published paths are resolved against.
+func (s *Server) SetAssembly(a *livstid.Assembly) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.a = a
+}
+
+// image returns the image published to path, relative to the output directory.
+func (s *Server) image(path string) *livstid.Image {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.a == nil {
+		return nil
+	}
+
+	out := filepath.Join(s.path, filepath.FromSlash(path))
+	for _, i := range s.a.Images {
+		if i.OutPath == out {
+			return i
+		}
+	}
+	return nil
+}
+
+// sameOrigin returns true if a request was made by a page served from the same host, rather than
+// forged by another site.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
 // HideHandler hides an image or album.
 func (s *Server) HideHandler() http.HandlerFunc {
 	return func(_ http.ResponseWriter, _ *http.Request) {
 		klog.Infof("hide")
 	}
 }
+
+// KeywordsHandler changes the keywords of an image, given the path it is published to as the "path"
+// parameter, adding each "add" parameter and removing each "remove" parameter. Keywords are written
+// to the image's XMP sidecar if Config.WriteSidecars is set, or else to the image itself, and are
+// published by the next build.
+func (s *Server) KeywordsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		path := r.PostForm.Get("path")
+		i := s.image(path)
+		if i == nil {
+			http.Error(w, fmt.Sprintf("no image is published to %q", path), http.StatusNotFound)
+			return
+		}
+
+		add, remove := r.PostForm["add"], r.PostForm["remove"]
+		et, err := exiftool.NewExiftool()
+		if err != nil {
+			klog.Errorf("exiftool: %v", err)
+			http.Error(w, "exiftool unavailable", http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			if err := et.Close(); err != nil {
+				klog.Errorf("Failed to close exiftool: %v", err)
+			}
+		}()
+
+		err = livstid.UpdateKeywords(et, i.InPath, s.c.WriteSidecars, func(ks []string) []string {
+			return editKeywords(ks, add, remove)
+		})
+		if err != nil {
+			klog.Errorf("keywords %s: %v", i.InPath, err)
+			http.Error(w, "unable to update keywords", http.StatusInternalServerError)
+			return
+		}
+
+		klog.Infof("updated keywords of %s: +%v -%v", i.InPath, add, remove)
+		fmt.Fprintf(w, "updated %s\n", path)
+	}
+}
+
+// editKeywords returns ks with the keywords in remove dropped and those in add appended.
+func editKeywords(ks, add, remove []string) []string {
+	out := []string{}
+	for _, k := range ks {
+		if !slices.Contains(remove, k) && !slices.Contains(out, k) {
+			out = append(out, k)
+		}
+	}
+	for _, k := range add {
+		if k != "" && !slices.Contains(remove, k) && !slices.Contains(out, k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package manage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tstromberg/livstid/pkg/livstid"
+)
+
+func TestKeywordsHandler(t *testing.T) {
+	s := New(&livstid.Config{}, "out")
+	s.SetAssembly(&livstid.Assembly{Images: []*livstid.Image{{InPath: "in/a.jpg", OutPath: filepath.Join("out", "2024", "a.jpg")}}})
+
+	tests := []struct {
+		name   string
+		method string
+		origin string
+		site   string
+		path   string
+		want   int
+	}{
+		{name: "GET", method: http.MethodGet, path: "2024/a.jpg", want: http.StatusMethodNotAllowed},
+		{name: "cross-origin", method: http.MethodPost, origin: "http://evil.example", path: "2024/a.jpg", want: http.StatusForbidden},
+		{name: "cross-site", method: http.MethodPost, site: "cross-site", path: "2024/a.jpg", want: http.StatusForbidden},
+		{name: "unknown image", method: http.MethodPost, origin: "http://example.com", site: "same-origin", path: "2024/b.jpg", want: http.StatusNotFound},
+		{name: "no path", method: http.MethodPost, want: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(tc.method, "http://example.com/keywords", strings.NewReader("path="+tc.path+"&add=beach"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if tc.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tc.site)
+		}
+		w := httptest.NewRecorder()
+		s.KeywordsHandler()(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	s := New(&livstid.Config{}, "out")
+	if i := s.image("2024/a.jpg"); i != nil {
+		t.Errorf("image before any build = %v, want nil", i)
+	}
+
+	a := &livstid.Image{OutPath: filepath.Join("out", "2024", "a.jpg")}
+	s.SetAssembly(&livstid.Assembly{Images: []*livstid.Image{a}})
+	tests := []struct {
+		path string
+		want *livstid.Image
+	}{
+		{path: "2024/a.jpg", want: a},
+		{path: "2024/../2024/a.jpg", want: a},
+		{path: "2024/b.jpg"},
+		{path: ""},
+	}
+	for _, tc := range tests {
+		if got := s.image(tc.path); got != tc.want {
+			t.Errorf("image(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestEditKeywords(t *testing.T) {
+	tests := []struct {
+		ks, add, remove []string
+		want            []string
+	}{
+		{ks: []string{"a", "b"}, add: []string{"c"}, want: []string{"a", "b", "c"}},
+		{ks: []string{"a", "b"}, remove: []string{"a"}, want: []string{"b"}},
+		{ks: []string{"a", "b", "a"}, add: []string{"c", "b", ""}, remove: []string{"a"}, want: []string{"b", "c"}},
+		{ks: nil, add: []string{"a"}, remove: []string{"a"}, want: []string{}},
+	}
+	for _, tc := range tests {
+		if got := editKeywords(tc.ks, tc.add, tc.remove); !slices.Equal(got, tc.want) {
+			t.Errorf("editKeywords(%v, %v, %v) = %v, want %v", tc.ks, tc.add, tc.remove, got, tc.want)
+		}
+	}
+}